@@ -0,0 +1,94 @@
+package atomicfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+//	先写入同一目录下的临时文件，提交时再改名为目标文件，
+//	写入过程中被中断时目标文件保持原样，不会留下写了一半的文件
+type File struct {
+	*os.File
+	path string
+	done bool
+}
+
+//	创建写入filePath的临时文件
+func Create(filePath string) (*File, error) {
+
+	file, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	//	临时文件只有所有者可以读写，改为普通数据文件的权限
+	err = file.Chmod(0644)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+
+	return &File{File: file, path: filePath}, nil
+}
+
+//	写入磁盘并替换目标文件
+func (file *File) Commit() error {
+
+	if file.done {
+		return errors.New("文件已经提交或放弃")
+	}
+	file.done = true
+
+	err := file.File.Sync()
+	if err != nil {
+		file.File.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	err = file.File.Close()
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	err = os.Rename(file.Name(), file.path)
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return nil
+}
+
+//	放弃尚未提交的内容并删除临时文件，提交后调用不起作用，因此可以用defer调用
+func (file *File) Close() error {
+
+	if file.done {
+		return nil
+	}
+	file.done = true
+
+	file.File.Close()
+
+	return os.Remove(file.Name())
+}
+
+//	原子地写入整个文件
+func WriteFile(filePath string, data []byte) error {
+
+	file, err := Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(data)
+	if err != nil {
+		return err
+	}
+
+	return file.Commit()
+}
@@ -0,0 +1,285 @@
+package calendar
+
+import (
+	"time"
+)
+
+const (
+	dateLayout = "20060102"
+)
+
+//	纽约证券交易所和纳斯达克的交易日历
+var (
+	//	正常收盘时间
+	regularClose = clock{16, 0}
+	//	提前收盘时间
+	earlyClose = clock{13, 0}
+	//	开盘时间
+	regularOpen = clock{9, 30}
+	//	交易所所在时区
+	location = loadLocation()
+
+	//	因特殊事件休市的日期
+	closures = map[string]string{
+		"19940427": "Nixon Funeral",
+		"20010911": "September 11",
+		"20010912": "September 11",
+		"20010913": "September 11",
+		"20010914": "September 11",
+		"20040611": "Reagan Funeral",
+		"20070102": "Ford Funeral",
+		"20121029": "Hurricane Sandy",
+		"20121030": "Hurricane Sandy",
+		"20181205": "Bush Funeral",
+		"20250109": "Carter Funeral",
+	}
+)
+
+//	时刻
+type clock struct {
+	Hour   int
+	Minute int
+}
+
+//	纽约时区，系统没有时区数据时使用美国东部标准时间
+func loadLocation() *time.Location {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		return time.FixedZone("EST", -5*60*60)
+	}
+
+	return loc
+}
+
+//	交易所所在时区
+func Location() *time.Location {
+	return location
+}
+
+//	当天是否休市，返回休市原因
+func Holiday(date time.Time) (string, bool) {
+
+	date = day(date)
+	year, month, dayOfMonth := date.Date()
+	weekday := date.Weekday()
+
+	if weekday == time.Saturday || weekday == time.Sunday {
+		return "Weekend", true
+	}
+
+	name, found := closures[date.Format(dateLayout)]
+	if found {
+		return name, true
+	}
+
+	switch {
+	//	元旦，周日顺延到周一，周六不提前
+	case month == time.January && (dayOfMonth == 1 || dayOfMonth == 2 && weekday == time.Monday):
+		return "New Year's Day", true
+	//	马丁路德金纪念日，1998年起为一月第三个周一
+	case year >= 1998 && month == time.January && weekday == time.Monday && (dayOfMonth-1)/7 == 2:
+		return "Martin Luther King Jr. Day", true
+	//	总统日，二月第三个周一
+	case month == time.February && weekday == time.Monday && (dayOfMonth-1)/7 == 2:
+		return "Washington's Birthday", true
+	//	耶稣受难日
+	case date.Equal(easter(year).AddDate(0, 0, -2)):
+		return "Good Friday", true
+	//	阵亡将士纪念日，五月最后一个周一
+	case month == time.May && weekday == time.Monday && dayOfMonth+7 > 31:
+		return "Memorial Day", true
+	//	六月节，2022年起
+	case year >= 2022 && observed(date, time.June, 19):
+		return "Juneteenth", true
+	//	独立日
+	case observed(date, time.July, 4):
+		return "Independence Day", true
+	//	劳动节，九月第一个周一
+	case month == time.September && weekday == time.Monday && dayOfMonth <= 7:
+		return "Labor Day", true
+	//	感恩节，十一月第四个周四
+	case month == time.November && weekday == time.Thursday && (dayOfMonth-1)/7 == 3:
+		return "Thanksgiving Day", true
+	//	圣诞节
+	case observed(date, time.December, 25):
+		return "Christmas Day", true
+	}
+
+	return "", false
+}
+
+//	节日在周六时提前到周五，在周日时顺延到周一
+func observed(date time.Time, month time.Month, dayOfMonth int) bool {
+
+	holiday := time.Date(date.Year(), month, dayOfMonth, 0, 0, 0, 0, time.UTC)
+	switch holiday.Weekday() {
+	case time.Saturday:
+		holiday = holiday.AddDate(0, 0, -1)
+	case time.Sunday:
+		holiday = holiday.AddDate(0, 0, 1)
+	}
+
+	return date.Equal(holiday)
+}
+
+//	复活节(格里高利历)
+func easter(year int) time.Time {
+	a := year % 19
+	b := year / 100
+	c := year % 100
+	d := (19*a + b - b/4 - (b-(b+8)/25+1)/3 + 15) % 30
+	e := (32 + 2*(b%4) + 2*(c/4) - d - c%4) % 7
+	f := d + e - 7*((a+11*d+22*e)/451) + 114
+
+	return time.Date(year, time.Month(f/31), f%31+1, 0, 0, 0, 0, time.UTC)
+}
+
+//	是否为交易日
+func IsTradingDay(date time.Time) bool {
+	_, holiday := Holiday(date)
+	return !holiday
+}
+
+//	是否提前收盘(独立日前一天、感恩节后一天、平安夜)
+func IsEarlyClose(date time.Time) bool {
+
+	date = day(date)
+	if !IsTradingDay(date) {
+		return false
+	}
+
+	_, month, dayOfMonth := date.Date()
+	weekday := date.Weekday()
+
+	switch {
+	case month == time.July && dayOfMonth == 3 && weekday != time.Friday:
+		return true
+	case month == time.November && weekday == time.Friday && (dayOfMonth-2)/7 == 3:
+		return true
+	case month == time.December && dayOfMonth == 24 && weekday != time.Friday:
+		return true
+	}
+
+	return false
+}
+
+//	交易日的开盘时间(交易所时区)
+func OpenTime(date time.Time) time.Time {
+	year, month, dayOfMonth := date.Date()
+	return time.Date(year, month, dayOfMonth, regularOpen.Hour, regularOpen.Minute, 0, 0, location)
+}
+
+//	交易日的收盘时间(交易所时区)
+func CloseTime(date time.Time) time.Time {
+	c := regularClose
+	if IsEarlyClose(date) {
+		c = earlyClose
+	}
+
+	year, month, dayOfMonth := date.Date()
+	return time.Date(year, month, dayOfMonth, c.Hour, c.Minute, 0, 0, location)
+}
+
+//	下一个交易日
+func NextTradingDay(date time.Time) time.Time {
+	date = day(date).AddDate(0, 0, 1)
+	for !IsTradingDay(date) {
+		date = date.AddDate(0, 0, 1)
+	}
+
+	return date
+}
+
+//	上一个交易日
+func PrevTradingDay(date time.Time) time.Time {
+	date = day(date).AddDate(0, 0, -1)
+	for !IsTradingDay(date) {
+		date = date.AddDate(0, 0, -1)
+	}
+
+	return date
+}
+
+//	在指定时刻已经收盘的最近一个交易日
+func LastCompletedTradingDay(now time.Time) time.Time {
+
+	now = now.In(location)
+	today := day(now)
+	if IsTradingDay(today) && !now.Before(CloseTime(today)) {
+		return today
+	}
+
+	return PrevTradingDay(today)
+}
+
+//	[from, to]之间的所有交易日
+func TradingDays(from, to time.Time) []time.Time {
+
+	days := make([]time.Time, 0)
+	for date := day(from); !date.After(day(to)); date = date.AddDate(0, 0, 1) {
+		if IsTradingDay(date) {
+			days = append(days, date)
+		}
+	}
+
+	return days
+}
+
+//	去掉时间部分，以UTC表示日期
+func day(date time.Time) time.Time {
+	year, month, dayOfMonth := date.Date()
+	return time.Date(year, month, dayOfMonth, 0, 0, 0, 0, time.UTC)
+}
+
+//	解析yyyymmdd格式的日期
+func ParseDate(date string) (time.Time, error) {
+	return time.Parse(dateLayout, date)
+}
+
+//	格式化为yyyymmdd格式的日期
+func FormatDate(date time.Time) string {
+	return date.Format(dateLayout)
+}
+
+//	yyyymmdd格式的两个日期之间(不含两端)的交易日数
+func MissingTradingDays(from, to string) (int, error) {
+
+	start, err := ParseDate(from)
+	if err != nil {
+		return 0, err
+	}
+
+	end, err := ParseDate(to)
+	if err != nil {
+		return 0, err
+	}
+
+	missing := 0
+	for date := NextTradingDay(start); date.Before(end); date = NextTradingDay(date) {
+		missing++
+	}
+
+	return missing, nil
+}
+
+//	yyyymmdd格式的[from, to]之间的所有交易日
+func TradingDates(from, to string) ([]string, error) {
+
+	start, err := ParseDate(from)
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := ParseDate(to)
+	if err != nil {
+		return nil, err
+	}
+
+	days := TradingDays(start, end)
+	dates := make([]string, 0, len(days))
+	for _, date := range days {
+		dates = append(dates, FormatDate(date))
+	}
+
+	return dates, nil
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/export"
+	"github.com/nzai/Tast/history"
+	"github.com/nzai/Tast/history/validate"
+	"github.com/nzai/Tast/indicator"
+	"github.com/nzai/Tast/intraday"
+	"github.com/nzai/Tast/peroidexterma"
+	"github.com/nzai/Tast/pipeline"
+	"github.com/nzai/Tast/server"
+	"github.com/nzai/Tast/stock"
+	"github.com/nzai/Tast/storage"
+	"github.com/nzai/Tast/trading"
+	"github.com/nzai/Tast/turtle"
+)
+
+//	子命令
+type command struct {
+	Name    string
+	Summary string
+	//	定义命令参数，返回执行函数，执行函数在ctx被取消时应尽快返回
+	Define func(flags *commandFlags) func(context.Context) error
+}
+
+//	命令参数，部分参数用于覆盖配置文件中的值
+type commandFlags struct {
+	*flag.FlagSet
+	overrides []configFlag
+}
+
+//	覆盖配置的参数
+type configFlag struct {
+	value        *string
+	section, key string
+}
+
+//	定义覆盖配置section.key的参数
+func (flags *commandFlags) config(name, section, key, usage string) {
+	flags.overrides = append(flags.overrides, configFlag{
+		value:   flags.String(name, "", fmt.Sprintf("%s(覆盖配置%s.%s)", usage, section, key)),
+		section: section,
+		key:     key,
+	})
+}
+
+//	定义股票代码参数
+func (flags *commandFlags) codes() *string {
+	return flags.String("codes", "", "股票代码，以逗号分隔，默认为所有股票")
+}
+
+//	解析参数并执行命令
+func (c *command) run(ctx context.Context, args []string) error {
+
+	flags := &commandFlags{FlagSet: flag.NewFlagSet("tast "+c.Name, flag.ExitOnError)}
+	execute := c.Define(flags)
+	flags.Parse(args)
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("无法识别的参数:%s", strings.Join(flags.Args(), " "))
+	}
+
+	for _, override := range flags.overrides {
+		if *override.value != "" {
+			config.Set(override.section, override.key, *override.value)
+		}
+	}
+
+	return execute(ctx)
+}
+
+//	根据命令行查找命令，命令可以由一个或两个单词组成
+func findCommand(args []string) (*command, []string) {
+
+	for words := 2; words > 0; words-- {
+		if len(args) < words {
+			continue
+		}
+
+		name := strings.Join(args[:words], " ")
+		for index := range commands {
+			if commands[index].Name == name {
+				return &commands[index], args[words:]
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+//	所有命令
+var commands = []command{
+	{"stocks update", "更新股票列表", func(flags *commandFlags) func(context.Context) error {
+		return stock.UpdateAll
+	}},
+	{"history update", "更新股票每日历史", func(flags *commandFlags) func(context.Context) error {
+		codes := flags.codes()
+		return func(ctx context.Context) error {
+			list, err := parseCodes(*codes)
+			if err != nil {
+				return err
+			}
+
+			return history.Update(ctx, list)
+		}
+	}},
+	{"history validate", "检查股票历史的数据质量", func(flags *commandFlags) func(context.Context) error {
+		codes := flags.codes()
+		flags.config("quarantine", "validate", "quarantine", "是否隔离有错误的历史记录")
+		return func(ctx context.Context) error {
+			list, err := parseCodes(*codes)
+			if err != nil {
+				return err
+			}
+
+			return validate.Update(ctx, list)
+		}
+	}},
+	{"history intraday", "导入CSV格式的分钟K线并与每日历史对齐", func(flags *commandFlags) func(context.Context) error {
+		code := flags.String("code", "", "分钟K线所属的股票代码")
+		file := flags.String("file", "", "CSV格式的分钟K线文件")
+		flags.config("regularonly", "intraday", "regularonly", "是否只保留常规交易时段")
+		return func(ctx context.Context) error {
+			return importIntraday(*code, *file)
+		}
+	}},
+	{"indicators update", "计算尚未保存的指标", func(flags *commandFlags) func(context.Context) error {
+		codes := indicatorFlags(flags)
+		return func(ctx context.Context) error {
+			return updateIndicators(ctx, *codes, false)
+		}
+	}},
+	{"indicators rebuild", "删除已保存的指标并重新计算", func(flags *commandFlags) func(context.Context) error {
+		codes := indicatorFlags(flags)
+		return func(ctx context.Context) error {
+			return updateIndicators(ctx, *codes, true)
+		}
+	}},
+	{"backtest", "测试交易策略，默认测试配置文件中的所有策略", func(flags *commandFlags) func(context.Context) error {
+		spec := flags.String("strategy", "", "策略，格式与配置strategy.strategies相同，例如macross:sma:10:50")
+		params := flags.String("params", "", "海龟交易系统的参数，例如holding=2,n=20,enter=20,exit=10,stop=20,trend=200")
+		tradingFlags(flags)
+		return func(ctx context.Context) error {
+			return backtest(ctx, *spec, *params)
+		}
+	}},
+	{"sweep", "遍历海龟交易系统的参数组合", func(flags *commandFlags) func(context.Context) error {
+		tradingFlags(flags)
+		for _, key := range []string{"trend", "adx", "indextrend", "volatilitymin", "volatilitymax"} {
+			flags.config(key, "filter", key, "入市过滤条件，可以是 开始-结束:步长 的范围")
+		}
+		return trading.TestAll
+	}},
+	{"report", "显示已保存的测试报告", func(flags *commandFlags) func(context.Context) error {
+		return func(ctx context.Context) error {
+			return report()
+		}
+	}},
+	{"import", "从目录导入文本格式的数据", func(flags *commandFlags) func(context.Context) error {
+		dir := flags.String("dir", "", "数据目录")
+		return func(ctx context.Context) error {
+			if *dir == "" {
+				return errors.New("必须指定导入的目录")
+			}
+
+			return transfer(*dir, "", "")
+		}
+	}},
+	{"export", "导出文本或Parquet格式的数据", func(flags *commandFlags) func(context.Context) error {
+		dir := flags.String("dir", "", "导出文本格式数据的目录")
+		parquet := flags.String("parquet", "", "导出Parquet文件的目录")
+		return func(ctx context.Context) error {
+			if *dir == "" && *parquet == "" {
+				return errors.New("必须指定导出的目录")
+			}
+
+			return transfer("", *dir, *parquet)
+		}
+	}},
+	{"serve", "启动HTTP服务，提供查询数据和启动回测的JSON接口", func(flags *commandFlags) func(context.Context) error {
+		flags.config("address", "server", "address", "监听地址")
+		return func(ctx context.Context) error {
+			return server.Serve(ctx, "")
+		}
+	}},
+	{"run", "依次更新股票、历史、指标并测试所有策略，只重新计算输入有变化的部分", func(flags *commandFlags) func(context.Context) error {
+		codes := flags.String("codes", "", "股票代码，以逗号分隔，默认为所有股票")
+		dryRun := flags.Bool("dry-run", false, "只输出需要重新计算的内容")
+		return func(ctx context.Context) error {
+			var list []string
+			if *codes != "" {
+				var err error
+				list, err = parseCodes(*codes)
+				if err != nil {
+					return err
+				}
+			}
+
+			return pipeline.Default().Run(ctx, list, *dryRun)
+		}
+	}},
+}
+
+//	指标相关的参数
+func indicatorFlags(flags *commandFlags) *string {
+	flags.config("timeframe", "indicator", "timeframe", "K线周期")
+	flags.config("estimators", "turtle", "estimators", "波动性估计方法")
+	flags.config("indicators", "indicator", "indicators", "技术指标")
+	return flags.codes()
+}
+
+//	测试相关的参数
+func tradingFlags(flags *commandFlags) {
+	flags.config("codes", "trading", "codes", "股票代码，以逗号分隔，默认为所有股票")
+	flags.config("timeframe", "indicator", "timeframe", "K线周期")
+	flags.config("volatility", "trading", "volatility", "作为N使用的波动性估计方法")
+	flags.config("fill", "trading", "fill", "推断日内价格路径的方式")
+}
+
+//	解析股票代码，为空时返回所有股票
+func parseCodes(value string) ([]string, error) {
+
+	codes := make([]string, 0)
+	for _, code := range strings.Split(value, ",") {
+		if strings.TrimSpace(code) != "" {
+			codes = append(codes, strings.ToUpper(strings.TrimSpace(code)))
+		}
+	}
+
+	if len(codes) > 0 {
+		return codes, nil
+	}
+
+	return stock.GetCodes()
+}
+
+//	计算指标，rebuild为true时先删除已保存的指标
+func updateIndicators(ctx context.Context, value string, rebuild bool) error {
+
+	codes, err := parseCodes(value)
+	if err != nil {
+		return err
+	}
+
+	if rebuild {
+		store, err := storage.Default()
+		if err != nil {
+			return err
+		}
+
+		slog.Info("删除已保存的指标", "codes", len(codes))
+		for _, code := range codes {
+			for _, kind := range storage.IndicatorKinds() {
+				err = store.Remove(code, kind)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	err = turtle.Update(ctx, codes)
+	if err != nil {
+		return err
+	}
+
+	err = peroidexterma.Update(ctx, codes)
+	if err != nil {
+		return err
+	}
+
+	return indicator.Update(ctx, codes)
+}
+
+//	测试策略并输出报告
+func backtest(ctx context.Context, spec, params string) error {
+
+	if params != "" {
+		if spec != "" {
+			return errors.New("不能同时指定strategy和params")
+		}
+
+		var err error
+		spec, err = turtleSpec(params)
+		if err != nil {
+			return err
+		}
+	}
+
+	if spec == "" {
+		err := trading.TestStrategies(ctx)
+		if err != nil {
+			return err
+		}
+
+		return report()
+	}
+
+	result, err := trading.TestStrategy(ctx, spec)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(result)
+
+	return nil
+}
+
+//	将 key=value 格式的海龟交易系统参数转换为策略描述
+func turtleSpec(params string) (string, error) {
+
+	keys := []string{"holding", "n", "enter", "exit", "stop", "trend", "adx", "indextrend", "volatilitymin", "volatilitymax"}
+	values := make(map[string]string)
+	for _, param := range strings.Split(params, ",") {
+		parts := strings.SplitN(param, "=", 2)
+		if len(parts) != 2 {
+			return "", fmt.Errorf("参数%s的格式不正确，应为 key=value", param)
+		}
+
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		_, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return "", fmt.Errorf("参数%s的值必须是整数", param)
+		}
+
+		values[key] = strings.TrimSpace(parts[1])
+	}
+
+	list := make([]string, 0, len(keys))
+	for index, key := range keys {
+		value, found := values[key]
+		if !found {
+			if index < 5 {
+				return "", fmt.Errorf("缺少海龟交易系统参数%s", key)
+			}
+			value = "0"
+		}
+		delete(values, key)
+
+		list = append(list, value)
+	}
+
+	for key := range values {
+		return "", fmt.Errorf("不支持的海龟交易系统参数%s", key)
+	}
+
+	return "turtle:" + strings.Join(list, ":"), nil
+}
+
+//	显示已保存的测试报告
+func report() error {
+
+	dataDir, err := config.GetDataDir()
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, name := range trading.ReportFiles() {
+		buffer, err := ioutil.ReadFile(filepath.Join(dataDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("==== %s ====\n%s\n", name, buffer)
+		found = true
+	}
+
+	if !found {
+		fmt.Println("还没有保存的测试报告")
+	}
+
+	return nil
+}
+
+//	导入导出文本格式的数据
+func transfer(importDir, exportDir, parquetDir string) error {
+
+	codes, err := stock.GetCodes()
+	if err != nil {
+		return err
+	}
+
+	if importDir != "" {
+		slog.Info("开始导入数据", "dir", importDir)
+		err = storage.Import(importDir, codes)
+		if err != nil {
+			return err
+		}
+	}
+
+	if exportDir != "" {
+		slog.Info("开始导出数据", "dir", exportDir)
+		err = storage.Export(exportDir, codes)
+		if err != nil {
+			return err
+		}
+	}
+
+	if parquetDir != "" {
+		err = export.Parquet(parquetDir, codes)
+		if err != nil {
+			return err
+		}
+	}
+
+	slog.Info("数据导入导出结束")
+
+	return nil
+}
+
+//	导入分钟K线并与每日历史对齐
+func importIntraday(code, filePath string) error {
+
+	if code == "" || filePath == "" {
+		return errors.New("导入分钟K线时必须指定股票代码和文件")
+	}
+	code = strings.ToUpper(code)
+
+	count, err := intraday.ImportCSV(code, filePath)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("导入分钟K线", "code", code, "file", filePath, "count", count)
+
+	histories, err := history.GetStockDailyHistory(code)
+	if err != nil {
+		return err
+	}
+
+	provider, err := intraday.Default()
+	if err != nil {
+		return err
+	}
+
+	alignment, err := intraday.Align(provider, code, histories)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("分钟K线与每日历史对齐",
+		"code", code,
+		"matched", len(alignment.Matched),
+		"mismatched", len(alignment.Mismatched),
+		"missing", len(alignment.Missing),
+		"orphans", len(alignment.Orphans))
+
+	return nil
+}
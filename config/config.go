@@ -56,7 +56,7 @@ func GetDataDir() (string, error) {
 	//	检查目录是否存在
 	_, err := os.Stat(dataDir)
 	if os.IsNotExist(err) {
-		err = os.Mkdir(dataDir, 0x777)
+		err = os.MkdirAll(dataDir, 0755)
 		if err != nil {
 			return "", err
 		}
@@ -64,3 +64,33 @@ func GetDataDir() (string, error) {
 
 	return dataDir, nil
 }
+
+//	获取整数配置
+func GetInt(section, key string, defaultValue int) int {
+	return configInstance.MustInt(section, key, defaultValue)
+}
+
+//	获取浮点数配置
+func GetFloat64(section, key string, defaultValue float64) float64 {
+	return configInstance.MustFloat64(section, key, defaultValue)
+}
+
+//	获取布尔配置
+func GetBool(section, key string, defaultValue bool) bool {
+	return configInstance.MustBool(section, key, defaultValue)
+}
+
+//	修改配置(只在内存中生效，不写回配置文件)
+func Set(section, key, value string) {
+	configInstance.SetValue(section, key, value)
+}
+
+//	获取某一节的所有配置，节不存在时返回空
+func GetSection(section string) map[string]string {
+	values := make(map[string]string)
+	for _, key := range configInstance.GetKeyList(section) {
+		values[key] = configInstance.MustValue(section, key, "")
+	}
+
+	return values
+}
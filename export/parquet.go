@@ -0,0 +1,216 @@
+package export
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+
+	"github.com/nzai/Tast/atomicfile"
+	"github.com/nzai/Tast/history"
+	"github.com/nzai/Tast/logging"
+	"github.com/nzai/Tast/peroidexterma"
+	"github.com/nzai/Tast/turtle"
+)
+
+const (
+	dailyDirName         = "daily"
+	turtleDirName        = "turtle"
+	peroidExtermaDirName = "peroid_exterma"
+	parquetFileName      = "part-0.parquet"
+	parquetParallel      = 4
+	dateLayout           = "20060102"
+)
+
+//	每日历史
+type dailyRow struct {
+	Date   int32   `parquet:"name=date, type=INT32, convertedtype=DATE"`
+	Open   float64 `parquet:"name=open, type=DOUBLE"`
+	Close  float64 `parquet:"name=close, type=DOUBLE"`
+	High   float64 `parquet:"name=high, type=DOUBLE"`
+	Low    float64 `parquet:"name=low, type=DOUBLE"`
+	Volume int64   `parquet:"name=volume, type=INT64"`
+}
+
+//	海龟指标
+type turtleRow struct {
+	Peroid int32   `parquet:"name=peroid, type=INT32"`
+	Date   int32   `parquet:"name=date, type=INT32, convertedtype=DATE"`
+	N      float64 `parquet:"name=n, type=DOUBLE"`
+	TR     float64 `parquet:"name=tr, type=DOUBLE"`
+	Valid  bool    `parquet:"name=valid, type=BOOLEAN"`
+}
+
+//	区间极值指标
+type peroidExtermaRow struct {
+	Peroid int32   `parquet:"name=peroid, type=INT32"`
+	Date   int32   `parquet:"name=date, type=INT32, convertedtype=DATE"`
+	Min    float64 `parquet:"name=min, type=DOUBLE"`
+	Max    float64 `parquet:"name=max, type=DOUBLE"`
+	Valid  bool    `parquet:"name=valid, type=BOOLEAN"`
+}
+
+//	将所有股票的每日历史和指标导出为按股票代码分区的Parquet文件
+//	目录结构为 dir/{daily,turtle,peroid_exterma}/code=XXX/part-0.parquet
+func Parquet(dir string, codes []string) error {
+
+	logger := logging.Stage("export")
+	logger.Info("开始导出Parquet文件", "dir", dir)
+
+	for _, code := range codes {
+		err := parquetStock(dir, code)
+		if err != nil {
+			return fmt.Errorf("导出股票%s的Parquet文件时发生错误:%v", code, err)
+		}
+	}
+
+	logger.Info("Parquet文件导出结束")
+
+	return nil
+}
+
+//	导出一只股票
+func parquetStock(dir, code string) error {
+
+	histories, err := history.GetStockDailyHistory(code)
+	if err != nil {
+		return err
+	}
+
+	dailyRows := make([]interface{}, 0, len(histories))
+	for _, h := range histories {
+		date, err := toDate(h.Date)
+		if err != nil {
+			return err
+		}
+
+		dailyRows = append(dailyRows, dailyRow{
+			Date:   date,
+			Open:   h.Open,
+			Close:  h.Close,
+			High:   h.High,
+			Low:    h.Low,
+			Volume: h.Volume,
+		})
+	}
+
+	err = writeParquet(filepath.Join(dir, dailyDirName, "code="+code), new(dailyRow), dailyRows)
+	if err != nil {
+		return err
+	}
+
+	turtles, err := turtle.GetStockIndex(code, history.Daily, turtle.WilderATR)
+	if err != nil {
+		return err
+	}
+
+	turtleRows := make([]interface{}, 0)
+	//	按周期排序，使每次导出的行顺序相同
+	peroids := make([]int, 0, len(turtles))
+	for peroid := range turtles {
+		peroids = append(peroids, peroid)
+	}
+	sort.Ints(peroids)
+
+	for _, peroid := range peroids {
+		for _, index := range turtles[peroid] {
+			date, err := toDate(index.Date)
+			if err != nil {
+				return err
+			}
+
+			turtleRows = append(turtleRows, turtleRow{
+				Peroid: int32(index.Peroid),
+				Date:   date,
+				N:      index.N,
+				TR:     index.TR,
+				Valid:  index.Valid,
+			})
+		}
+	}
+
+	err = writeParquet(filepath.Join(dir, turtleDirName, "code="+code), new(turtleRow), turtleRows)
+	if err != nil {
+		return err
+	}
+
+	extermas, err := peroidexterma.GetStockIndex(code, history.Daily)
+	if err != nil {
+		return err
+	}
+
+	extermaRows := make([]interface{}, 0)
+	//	按周期排序，使每次导出的行顺序相同
+	peroids = make([]int, 0, len(extermas))
+	for peroid := range extermas {
+		peroids = append(peroids, peroid)
+	}
+	sort.Ints(peroids)
+
+	for _, peroid := range peroids {
+		for _, index := range extermas[peroid] {
+			date, err := toDate(index.Date)
+			if err != nil {
+				return err
+			}
+
+			extermaRows = append(extermaRows, peroidExtermaRow{
+				Peroid: int32(index.Peroid),
+				Date:   date,
+				Min:    index.Min,
+				Max:    index.Max,
+				Valid:  index.Valid,
+			})
+		}
+	}
+
+	return writeParquet(filepath.Join(dir, peroidExtermaDirName, "code="+code), new(peroidExtermaRow), extermaRows)
+}
+
+//	写入Parquet文件
+func writeParquet(dir string, schema interface{}, rows []interface{}) error {
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	file, err := atomicfile.Create(filepath.Join(dir, parquetFileName))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	parquetWriter, err := writer.NewParquetWriterFromWriter(file, schema, parquetParallel)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		err = parquetWriter.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = parquetWriter.WriteStop()
+	if err != nil {
+		return err
+	}
+
+	return file.Commit()
+}
+
+//	将yyyymmdd格式的日期转换为Parquet的DATE(自1970-01-01起的天数)
+func toDate(date string) (int32, error) {
+
+	t, err := time.Parse(dateLayout, date)
+	if err != nil {
+		return 0, err
+	}
+
+	return int32(t.Unix() / 86400), nil
+}
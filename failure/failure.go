@@ -0,0 +1,158 @@
+package failure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/logging"
+	"github.com/nzai/Tast/metrics"
+)
+
+const (
+	configSection   = "run"
+	configStrictKey = "strict"
+)
+
+//	一只股票处理失败的原因
+type Failure struct {
+	Stage  string
+	Code   string
+	Peroid int //	0表示与周期无关
+	Err    error
+}
+
+func (failure Failure) String() string {
+	if failure.Peroid > 0 {
+		return fmt.Sprintf("%s(周期%d): %v", failure.Code, failure.Peroid, failure.Err)
+	}
+
+	return fmt.Sprintf("%s: %v", failure.Code, failure.Err)
+}
+
+//	阶段中部分股票处理失败
+type Errors struct {
+	Stage    string
+	Total    int //	已处理的股票数量，包括失败的
+	Failures []Failure
+}
+
+func (errs *Errors) Error() string {
+	lines := []string{fmt.Sprintf("阶段%s有%d/%d只股票处理失败", errs.Stage, len(errs.Failures), errs.Total)}
+	for _, failure := range errs.Failures {
+		lines = append(lines, "\t"+failure.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+//	失败的股票代码
+func (errs *Errors) Codes() []string {
+	codes := make([]string, 0, len(errs.Failures))
+	for _, failure := range errs.Failures {
+		codes = append(codes, failure.Code)
+	}
+
+	return codes
+}
+
+//	某个周期计算失败
+type PeroidError struct {
+	Peroid int
+	Err    error
+}
+
+func (err *PeroidError) Error() string {
+	return fmt.Sprintf("周期%d:%v", err.Peroid, err.Err)
+}
+
+func (err *PeroidError) Unwrap() error {
+	return err.Err
+}
+
+//	为错误附加周期
+func WithPeroid(peroid int, err error) error {
+	return &PeroidError{Peroid: peroid, Err: err}
+}
+
+//	是否为严格模式，严格模式下任何一只股票失败都立即停止
+func Strict() bool {
+	return config.GetBool(configSection, configStrictKey, false)
+}
+
+//	收集一个阶段中各股票的处理结果，可以并发使用
+type Collector struct {
+	stage    string
+	strict   bool
+	mutex    sync.Mutex
+	total    int
+	failures []Failure
+}
+
+func New(stage string) *Collector {
+	return &Collector{stage: stage, strict: Strict(), failures: make([]Failure, 0)}
+}
+
+//	记录一只股票的处理结果，err为nil表示成功
+func (collector *Collector) Record(code string, err error) {
+
+	//	被取消的股票不算处理过，由调用者返回取消的原因
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+
+	collector.mutex.Lock()
+	collector.total++
+	collector.mutex.Unlock()
+
+	if err == nil {
+		metrics.CodeProcessed(collector.stage)
+		return
+	}
+
+	failure := Failure{Stage: collector.stage, Code: code, Err: err}
+	var peroidError *PeroidError
+	if errors.As(err, &peroidError) {
+		failure.Peroid, failure.Err = peroidError.Peroid, peroidError.Err
+	}
+
+	logger := logging.Stage(collector.stage).With("code", code)
+	if failure.Peroid > 0 {
+		logger = logger.With("peroid", failure.Peroid)
+	}
+	logger.Error("处理股票失败", "error", failure.Err)
+	metrics.StageFailures.Inc(collector.stage)
+
+	collector.mutex.Lock()
+	collector.failures = append(collector.failures, failure)
+	collector.mutex.Unlock()
+}
+
+//	严格模式下已经有股票失败，不应再处理其他股票
+func (collector *Collector) Stopped() bool {
+
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	return collector.strict && len(collector.failures) > 0
+}
+
+//	汇总的错误，没有失败时返回nil
+func (collector *Collector) Err() error {
+
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	if len(collector.failures) == 0 {
+		return nil
+	}
+
+	return &Errors{
+		Stage:    collector.stage,
+		Total:    collector.total,
+		Failures: append([]Failure(nil), collector.failures...),
+	}
+}
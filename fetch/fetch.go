@@ -0,0 +1,313 @@
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nzai/Tast/atomicfile"
+	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/logging"
+	"github.com/nzai/Tast/metrics"
+)
+
+const (
+	configSection       = "fetch"
+	configTimeoutKey    = "timeout"
+	configRetriesKey    = "retries"
+	configBackoffKey    = "backoff"
+	configMaxBackoffKey = "maxbackoff"
+	configRateKey       = "rate"
+	configBurstKey      = "burst"
+	configUserAgentKey  = "useragent"
+	configCacheDirKey   = "cachedir"
+	configCacheTTLKey   = "cachettl"
+	defaultCacheDirName = "Cache"
+	defaultUserAgent    = "Mozilla/5.0 (compatible; Tast)"
+)
+
+//	下载参数
+type Options struct {
+	Timeout    time.Duration //	单次请求的超时时间
+	Retries    int           //	遇到网络错误、429或5xx时最多重试的次数
+	Backoff    time.Duration //	第一次重试前的等待时间，之后每次加倍
+	MaxBackoff time.Duration //	重试前等待时间的上限
+	Rate       float64       //	每秒最多发出的请求数，不大于0时不限速
+	Burst      int           //	允许连续发出的请求数
+	UserAgent  string
+	CacheDir   string        //	缓存下载内容的目录
+	CacheTTL   time.Duration //	缓存的有效期，为0时不缓存
+}
+
+//	从配置文件读取下载参数，缓存目录默认为数据目录下的Cache
+func DefaultOptions() (Options, error) {
+
+	cacheDir := config.GetString(configSection, configCacheDirKey, "")
+	if cacheDir == "" {
+		dataDir, err := config.GetDataDir()
+		if err != nil {
+			return Options{}, err
+		}
+		cacheDir = filepath.Join(dataDir, defaultCacheDirName)
+	}
+
+	return Options{
+		Timeout:    time.Duration(config.GetInt(configSection, configTimeoutKey, 60)) * time.Second,
+		Retries:    config.GetInt(configSection, configRetriesKey, 4),
+		Backoff:    time.Duration(config.GetInt(configSection, configBackoffKey, 500)) * time.Millisecond,
+		MaxBackoff: time.Duration(config.GetInt(configSection, configMaxBackoffKey, 30)) * time.Second,
+		Rate:       config.GetFloat64(configSection, configRateKey, 2),
+		Burst:      config.GetInt(configSection, configBurstKey, 4),
+		UserAgent:  config.GetString(configSection, configUserAgentKey, defaultUserAgent),
+		CacheDir:   cacheDir,
+		CacheTTL:   time.Duration(config.GetFloat64(configSection, configCacheTTLKey, 12) * float64(time.Hour)),
+	}, nil
+}
+
+//	带有超时、重试、限速和磁盘缓存的下载器，可以并发使用
+type Fetcher struct {
+	options Options
+	client  *http.Client
+	limiter *limiter
+}
+
+func New(options Options) *Fetcher {
+	return &Fetcher{
+		options: options,
+		client:  &http.Client{Timeout: options.Timeout},
+		limiter: newLimiter(options.Rate, options.Burst),
+	}
+}
+
+var (
+	defaultFetcher      *Fetcher
+	defaultFetcherMutex sync.Mutex
+)
+
+//	所有下载共用的下载器，第一次使用时根据配置创建，因此限速对所有下载生效
+func Default() (*Fetcher, error) {
+
+	defaultFetcherMutex.Lock()
+	defer defaultFetcherMutex.Unlock()
+
+	if defaultFetcher == nil {
+		options, err := DefaultOptions()
+		if err != nil {
+			return nil, err
+		}
+		defaultFetcher = New(options)
+	}
+
+	return defaultFetcher, nil
+}
+
+//	服务器返回的状态码不是2xx
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (err *StatusError) Error() string {
+	return fmt.Sprintf("请求%s返回%d %s", err.URL, err.StatusCode, http.StatusText(err.StatusCode))
+}
+
+func (fetcher *Fetcher) Get(ctx context.Context, url string) ([]byte, error) {
+	return fetcher.Do(ctx, "GET", url, "", nil)
+}
+
+func (fetcher *Fetcher) Post(ctx context.Context, url, contentType string, body []byte) ([]byte, error) {
+	return fetcher.Do(ctx, "POST", url, contentType, body)
+}
+
+//	发出POST请求，只使用since之后保存的缓存，数据源在某个时间之后更新时使用
+func (fetcher *Fetcher) PostSince(ctx context.Context, url, contentType string, body []byte, since time.Time) ([]byte, error) {
+	return fetcher.do(ctx, "POST", url, contentType, body, since)
+}
+
+//	发出请求并返回响应的内容，缓存有效时直接返回缓存的内容
+func (fetcher *Fetcher) Do(ctx context.Context, method, url, contentType string, body []byte) ([]byte, error) {
+	return fetcher.do(ctx, method, url, contentType, body, time.Time{})
+}
+
+func (fetcher *Fetcher) do(ctx context.Context, method, url, contentType string, body []byte, since time.Time) ([]byte, error) {
+
+	key := cacheKey(method, url, body)
+	data, found := fetcher.loadCache(key, since)
+	if found {
+		metrics.FetchCacheHits.Inc("")
+		return data, nil
+	}
+
+	logger := logging.Stage("fetch")
+	for attempt := 0; ; attempt++ {
+
+		data, retryAfter, err := fetcher.try(ctx, method, url, contentType, body)
+		if err == nil {
+			err = fetcher.saveCache(key, data)
+			if err != nil {
+				logger.Warn("保存下载缓存失败", "url", url, "error", err)
+			}
+
+			return data, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		reason, retryable := retryReason(err)
+		if !retryable || attempt >= fetcher.options.Retries {
+			return nil, err
+		}
+
+		//	服务器要求的等待时间更长时以服务器为准
+		delay := fetcher.backoff(attempt)
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+
+		metrics.FetchRetries.Inc(reason)
+		logger.Warn("下载失败，稍后重试", "url", url, "attempt", attempt+1, "delay", delay.String(), "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+//	发出一次请求，失败时同时返回服务器通过Retry-After要求的等待时间
+func (fetcher *Fetcher) try(ctx context.Context, method, url, contentType string, body []byte) ([]byte, time.Duration, error) {
+
+	err := fetcher.limiter.Wait(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if fetcher.options.UserAgent != "" {
+		request.Header.Set("User-Agent", fetcher.options.UserAgent)
+	}
+
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+
+	response, err := fetcher.client.Do(request)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer response.Body.Close()
+
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		var retryAfter time.Duration
+		seconds, err := strconv.Atoi(response.Header.Get("Retry-After"))
+		if err == nil && seconds > 0 {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+
+		return nil, retryAfter, &StatusError{URL: url, StatusCode: response.StatusCode}
+	}
+
+	return data, 0, nil
+}
+
+//	网络错误、429和5xx可以重试，返回记录在监控指标中的原因
+func retryReason(err error) (string, bool) {
+
+	var statusError *StatusError
+	if !errors.As(err, &statusError) {
+		return "error", true
+	}
+
+	if statusError.StatusCode == http.StatusTooManyRequests || statusError.StatusCode >= 500 {
+		return strconv.Itoa(statusError.StatusCode), true
+	}
+
+	return "", false
+}
+
+//	第attempt次重试前的等待时间，按指数增长并随机取上半段，避免多个请求同时重试
+func (fetcher *Fetcher) backoff(attempt int) time.Duration {
+
+	//	先与上限比较再加倍，避免次数很多时溢出
+	delay := fetcher.options.MaxBackoff
+	if attempt < 63 && fetcher.options.Backoff > 0 && fetcher.options.Backoff <= delay>>uint(attempt) {
+		delay = fetcher.options.Backoff << uint(attempt)
+	}
+
+	if delay <= 0 {
+		return 0
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+//	缓存文件名由请求方法、地址和内容决定
+func cacheKey(method, url string, body []byte) string {
+
+	hash := sha1.New()
+	fmt.Fprintf(hash, "%s\n%s\n", method, url)
+	hash.Write(body)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+//	读取有效期内并且在since之后保存的缓存
+func (fetcher *Fetcher) loadCache(key string, since time.Time) ([]byte, bool) {
+
+	if fetcher.options.CacheTTL <= 0 || fetcher.options.CacheDir == "" {
+		return nil, false
+	}
+
+	filePath := filepath.Join(fetcher.options.CacheDir, key)
+	info, err := os.Stat(filePath)
+	if err != nil || time.Now().Sub(info.ModTime()) > fetcher.options.CacheTTL || info.ModTime().Before(since) {
+		return nil, false
+	}
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, false
+	}
+
+	return data, true
+}
+
+//	保存下载的内容
+func (fetcher *Fetcher) saveCache(key string, data []byte) error {
+
+	if fetcher.options.CacheTTL <= 0 || fetcher.options.CacheDir == "" {
+		return nil
+	}
+
+	err := os.MkdirAll(fetcher.options.CacheDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(filepath.Join(fetcher.options.CacheDir, key), data)
+}
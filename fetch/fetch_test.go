@@ -0,0 +1,302 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//	按顺序返回statuses中的状态码，用完后返回200，同时记录请求次数
+func newServer(t *testing.T, statuses []int, retryAfter string) (*httptest.Server, *int32) {
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		index := int(atomic.AddInt32(&calls, 1)) - 1
+		if index < len(statuses) {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(statuses[index])
+			return
+		}
+
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &calls
+}
+
+//	不限速、不缓存、几乎不等待的下载参数
+func testOptions() Options {
+	return Options{
+		Timeout:    time.Second * 5,
+		Retries:    3,
+		Backoff:    time.Millisecond,
+		MaxBackoff: time.Millisecond * 10,
+	}
+}
+
+func TestRetryOnServerErrors(t *testing.T) {
+
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable} {
+		server, calls := newServer(t, []int{status, status}, "")
+
+		data, err := New(testOptions()).Get(context.Background(), server.URL)
+		if err != nil {
+			t.Fatalf("状态码%d重试后仍然失败: %v", status, err)
+		}
+
+		if string(data) != "ok" || atomic.LoadInt32(calls) != 3 {
+			t.Errorf("状态码%d: 返回%q，请求%d次，应为\"ok\"和3次", status, data, atomic.LoadInt32(calls))
+		}
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+
+	server, calls := newServer(t, []int{502, 502, 502, 502, 502}, "")
+
+	options := testOptions()
+	options.Retries = 2
+	_, err := New(options).Get(context.Background(), server.URL)
+
+	var statusError *StatusError
+	if !errors.As(err, &statusError) || statusError.StatusCode != 502 {
+		t.Fatalf("应返回502的StatusError，实际为%v", err)
+	}
+
+	if atomic.LoadInt32(calls) != 3 {
+		t.Errorf("重试2次应请求3次，实际为%d次", atomic.LoadInt32(calls))
+	}
+}
+
+func TestNoRetryOnClientErrors(t *testing.T) {
+
+	for _, status := range []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound} {
+		server, calls := newServer(t, []int{status}, "")
+
+		_, err := New(testOptions()).Get(context.Background(), server.URL)
+
+		var statusError *StatusError
+		if !errors.As(err, &statusError) || statusError.StatusCode != status {
+			t.Fatalf("应返回%d的StatusError，实际为%v", status, err)
+		}
+
+		if atomic.LoadInt32(calls) != 1 {
+			t.Errorf("状态码%d不应重试，实际请求%d次", status, atomic.LoadInt32(calls))
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+
+	server, calls := newServer(t, []int{http.StatusTooManyRequests}, "1")
+
+	start := time.Now()
+	_, err := New(testOptions()).Get(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//	服务器要求的等待时间长于退避时间
+	elapsed := time.Since(start)
+	if elapsed < time.Second {
+		t.Errorf("应按Retry-After等待1秒后重试，实际只等待了%v", elapsed)
+	}
+
+	if atomic.LoadInt32(calls) != 2 {
+		t.Errorf("应请求2次，实际为%d次", atomic.LoadInt32(calls))
+	}
+}
+
+func TestRetryAfterCanceled(t *testing.T) {
+
+	server, _ := newServer(t, []int{http.StatusTooManyRequests}, "60")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	start := time.Now()
+	_, err := New(testOptions()).Get(ctx, server.URL)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("等待重试时被取消应返回ctx的错误，实际为%v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second*5 {
+		t.Errorf("被取消后应立即返回，实际用时%v", elapsed)
+	}
+}
+
+func TestBackoffCap(t *testing.T) {
+
+	fetcher := New(Options{Backoff: time.Millisecond * 100, MaxBackoff: time.Second})
+	for attempt := 0; attempt < 70; attempt++ {
+		//	每次加倍，不超过上限，随机取上半段
+		expected := time.Millisecond * 100 << uint(attempt)
+		if attempt >= 4 {
+			expected = time.Second
+		}
+
+		for count := 0; count < 20; count++ {
+			delay := fetcher.backoff(attempt)
+			if delay < expected/2 || delay > expected {
+				t.Fatalf("第%d次重试的等待时间%v不在[%v, %v]之间", attempt, delay, expected/2, expected)
+			}
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+
+	server, calls := newServer(t, nil, "")
+
+	options := testOptions()
+	options.Rate, options.Burst = 20, 2
+	fetcher := New(options)
+
+	//	先用掉积攒的2个令牌，之后每50毫秒一个
+	start := time.Now()
+	for count := 0; count < 8; count++ {
+		_, err := fetcher.Get(context.Background(), server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	elapsed := time.Since(start)
+	if elapsed < time.Millisecond*250 {
+		t.Errorf("每秒20次、允许连续2次时8次请求至少需要300毫秒，实际为%v", elapsed)
+	}
+
+	if atomic.LoadInt32(calls) != 8 {
+		t.Errorf("应请求8次，实际为%d次", atomic.LoadInt32(calls))
+	}
+}
+
+func TestLimiterCanceled(t *testing.T) {
+
+	limiter := newLimiter(1, 1)
+	err := limiter.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	err = limiter.Wait(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("令牌用完后被取消应返回ctx的错误，实际为%v", err)
+	}
+}
+
+func TestCache(t *testing.T) {
+
+	server, calls := newServer(t, nil, "")
+
+	options := testOptions()
+	options.CacheDir, options.CacheTTL = filepath.Join(t.TempDir(), "Cache"), time.Hour
+	fetcher := New(options)
+
+	for count := 0; count < 3; count++ {
+		data, err := fetcher.Post(context.Background(), server.URL, "application/json", []byte("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != "ok" {
+			t.Fatalf("返回%q，应为\"ok\"", data)
+		}
+	}
+
+	if atomic.LoadInt32(calls) != 1 {
+		t.Fatalf("有效期内应使用缓存，实际请求%d次", atomic.LoadInt32(calls))
+	}
+
+	//	请求内容不同时不使用缓存
+	_, err := fetcher.Post(context.Background(), server.URL, "application/json", []byte("[]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if atomic.LoadInt32(calls) != 2 {
+		t.Fatalf("请求内容不同时不应使用缓存，实际请求%d次", atomic.LoadInt32(calls))
+	}
+
+	//	缓存过期后重新下载
+	expired := time.Now().Add(-time.Hour * 2)
+	err = os.Chtimes(filepath.Join(options.CacheDir, cacheKey("POST", server.URL, []byte("{}"))), expired, expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fetcher.Post(context.Background(), server.URL, "application/json", []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if atomic.LoadInt32(calls) != 3 {
+		t.Errorf("缓存过期后应重新下载，实际请求%d次", atomic.LoadInt32(calls))
+	}
+}
+
+func TestFailuresNotCached(t *testing.T) {
+
+	server, calls := newServer(t, []int{http.StatusNotFound}, "")
+
+	options := testOptions()
+	options.CacheDir, options.CacheTTL = t.TempDir(), time.Hour
+	fetcher := New(options)
+
+	_, err := fetcher.Get(context.Background(), server.URL)
+	if err == nil {
+		t.Fatal("404应返回错误")
+	}
+
+	data, err := fetcher.Get(context.Background(), server.URL)
+	if err != nil || string(data) != "ok" {
+		t.Fatalf("失败的请求不应缓存，返回%q %v", data, err)
+	}
+
+	if atomic.LoadInt32(calls) != 2 {
+		t.Errorf("应请求2次，实际为%d次", atomic.LoadInt32(calls))
+	}
+}
+
+func TestCacheSince(t *testing.T) {
+
+	server, calls := newServer(t, nil, "")
+
+	options := testOptions()
+	options.CacheDir, options.CacheTTL = t.TempDir(), time.Hour
+	fetcher := New(options)
+
+	//	缓存在since之后保存时使用缓存
+	for count := 0; count < 2; count++ {
+		_, err := fetcher.PostSince(context.Background(), server.URL, "application/json", []byte("{}"), time.Now().Add(-time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if atomic.LoadInt32(calls) != 1 {
+		t.Fatalf("缓存在since之后保存时应使用缓存，实际请求%d次", atomic.LoadInt32(calls))
+	}
+
+	//	数据源在缓存之后更新时重新下载，即使缓存仍在有效期内
+	_, err := fetcher.PostSince(context.Background(), server.URL, "application/json", []byte("{}"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if atomic.LoadInt32(calls) != 2 {
+		t.Errorf("缓存在since之前保存时应重新下载，实际请求%d次", atomic.LoadInt32(calls))
+	}
+}
@@ -0,0 +1,61 @@
+package fetch
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//	令牌桶，以rate的速度补充令牌，最多积攒burst个，每次请求消耗一个
+type limiter struct {
+	mutex  sync.Mutex
+	rate   float64 //	每秒补充的令牌数，不大于0时不限速
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &limiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+//	等待直到取得一个令牌，ctx被取消时返回错误
+func (limiter *limiter) Wait(ctx context.Context) error {
+
+	if limiter.rate <= 0 {
+		return ctx.Err()
+	}
+
+	for {
+		limiter.mutex.Lock()
+		now := time.Now()
+		limiter.tokens += now.Sub(limiter.last).Seconds() * limiter.rate
+		if limiter.tokens > limiter.burst {
+			limiter.tokens = limiter.burst
+		}
+		limiter.last = now
+
+		if limiter.tokens >= 1 {
+			limiter.tokens--
+			limiter.mutex.Unlock()
+			return nil
+		}
+
+		//	还需要等待的时间
+		wait := time.Duration((1 - limiter.tokens) / limiter.rate * float64(time.Second))
+		limiter.mutex.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -1,29 +1,30 @@
 package history
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"net/http"
-	"os"
-	"path/filepath"
+	"math"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/calendar"
+	"github.com/nzai/Tast/failure"
+	"github.com/nzai/Tast/fetch"
+	"github.com/nzai/Tast/logging"
+	"github.com/nzai/Tast/metrics"
 	"github.com/nzai/Tast/stock"
+	"github.com/nzai/Tast/storage"
 )
 
 const (
 	historyDirName        = "History"
-	dailyDataFileName     = "Daily.txt"
 	updateGoroutinesCount = 8
+	priceTolerance        = 1e-5 //	比较价格时允许的相对误差
 )
 
 //	股票历史
@@ -52,77 +53,139 @@ func (slice StockDailyHistories) Swap(i, j int) {
 	slice[i], slice[j] = slice[j], slice[i]
 }
 
-//	更新股票历史
-func UpdateAll() error {
-
-	log.Print("开始更新股票历史")
+//	更新所有股票的历史
+func UpdateAll(ctx context.Context) error {
 
-	//	数据保存目录
-	dataDir, err := config.GetDataDir()
+	//	获取所有的股票
+	codes, err := stock.GetCodes()
 	if err != nil {
 		return err
 	}
 
-	//	获取所有的股票
-	stocks, err := stock.GetAll()
+	return Update(ctx, codes)
+}
+
+//	更新指定股票的历史，ctx被取消时不再开始新的下载，已开始的下载也会中止
+func Update(ctx context.Context, codes []string) error {
+
+	logger := logging.Stage("history")
+	logger.Info("开始更新股票历史", "codes", len(codes))
+
+	//	数据存储
+	store, err := storage.Default()
 	if err != nil {
 		return err
 	}
 
 	chanSend := make(chan int, updateGoroutinesCount)
-	chanReceive := make(chan int)
-
-	//	并发获取股票历史
-	go func() {
-		for _, stock := range stocks {
-			go func(code string) {
-				//	更新每只股票的历史
-				err = updateStock(code, dataDir)
-				if err != nil {
-					log.Fatal(err)
-				}
-				<-chanSend
-				chanReceive <- 1
-			}(stock.Code)
-
-			chanSend <- 1
+	failures := failure.New("history")
+	metrics.WorkersCapacity.Set("history", updateGoroutinesCount)
+
+	//	并发获取股票历史，一只股票失败不影响其他股票
+	var wait sync.WaitGroup
+	for _, code := range codes {
+		//	严格模式下发生错误后不再处理其他股票
+		if failures.Stopped() || ctx.Err() != nil {
+			break
 		}
-	}()
+
+		chanSend <- 1
+		wait.Add(1)
+		go func(code string) {
+			defer wait.Done()
+
+			metrics.WorkersBusy.Inc("history")
+			//	更新每只股票的历史
+			failures.Record(code, updateStock(ctx, code, store))
+			metrics.WorkersBusy.Dec("history")
+			<-chanSend
+		}(code)
+	}
 
 	//	阻塞，直到所有股票更新完历史
-	for _, _ = range stocks {
-		<-chanReceive
+	wait.Wait()
+
+	if ctx.Err() != nil {
+		logger.Warn("股票历史更新被取消")
+		return ctx.Err()
 	}
 
-	log.Print("股票历史更新成功")
+	logger.Info("股票历史更新结束")
 
-	return err
+	return failures.Err()
 }
 
 //	更新股票历史
-func updateStock(code string, dataDir string) error {
+func updateStock(ctx context.Context, code string, store storage.Store) error {
 	//log.Print(code)
-	dir := filepath.Join(dataDir, code)
-	_, err := os.Stat(dir)
-	if os.IsNotExist(err) {
-		err = os.Mkdir(dir, 0x777)
+	return updateStockDaily(ctx, code, store)
+}
+
+//	更新股票每日历史
+func updateStockDaily(ctx context.Context, code string, store storage.Store) error {
+
+	found, err := store.Exists(code, storage.KindDaily)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		//	如果没有保存过就从纳斯达克更新股票复权每日历史
+		_, err := getFromNasdaq(ctx, code, store)
+		return err
+	}
+
+	histories, err := load(code, storage.KindDaily, store)
+	if err != nil {
+		return err
+	}
+
+	//	最近一个已收盘的交易日的数据已经保存过就不需要更新
+	latest := calendar.FormatDate(calendar.LastCompletedTradingDay(time.Now()))
+	if len(histories) > 0 && histories[len(histories)-1].Date >= latest {
+		return nil
+	}
+
+	downloaded, err := downloadFromNasdaq(ctx, code)
+	if err != nil {
+		return err
+	}
+
+	logger := logging.Stage("history")
+	if adjusted(histories, downloaded) {
+		//	分红、拆股等使复权价格整体变化，已保存的记录全部作废，已隔离的记录也随之失效
+		logger.Info("复权价格有变化，替换全部历史", "code", code, "count", len(downloaded))
+
+		err = store.Remove(code, storage.KindQuarantine)
 		if err != nil {
 			return err
 		}
+
+		histories = downloaded
+	} else {
+		//	只追加新的交易日，已保存(以及已隔离)的记录保持不变
+		count := len(histories)
+		for _, history := range downloaded {
+			if count == 0 || history.Date > histories[count-1].Date {
+				histories = append(histories, history)
+			}
+		}
+
+		if len(histories) == count {
+			return nil
+		}
+
+		logger.Info("新增交易日的历史", "code", code, "count", len(histories)-count)
 	}
 
-	return updateStockDaily(code, dir)
-}
+	err = save(code, storage.KindDaily, histories, store)
+	if err != nil {
+		return err
+	}
 
-//	更新股票每日历史
-func updateStockDaily(code string, codeDataDir string) error {
-
-	//	每日历史文件
-	filePath := filepath.Join(codeDataDir, dailyDataFileName)
-	_, err := os.Stat(filePath)
-	if os.IsNotExist(err) {
-		//	如果文件不存在就从纳斯达克更新股票复权每日历史
-		_, err := getFromNasdaq(code, filePath)
+	//	历史有变化，已经计算的指标需要重新计算
+	for _, kind := range storage.IndicatorKinds() {
+		err = store.Remove(code, kind)
 		if err != nil {
 			return err
 		}
@@ -131,23 +194,64 @@ func updateStockDaily(code string, codeDataDir string) error {
 	return nil
 }
 
-//	从纳斯达克更新股票复权每日历史
-func getFromNasdaq(code string, filePath string) ([]DailyHistory, error) {
+//	下载的历史与已保存的历史在相同日期上的价格是否不同
+func adjusted(histories, downloaded []DailyHistory) bool {
+
+	saved := make(map[string]DailyHistory, len(histories))
+	for _, history := range histories {
+		saved[history.Date] = history
+	}
+
+	for _, history := range downloaded {
+		previous, found := saved[history.Date]
+		if !found {
+			continue
+		}
+
+		if !samePrice(previous.Open, history.Open) || !samePrice(previous.Close, history.Close) ||
+			!samePrice(previous.High, history.High) || !samePrice(previous.Low, history.Low) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//	保存时会损失精度，差别在误差范围内视为相同
+func samePrice(a, b float64) bool {
+	return math.Abs(a-b) <= priceTolerance*math.Max(1, math.Abs(b))
+}
+
+//	从纳斯达克下载股票复权每日历史
+func downloadFromNasdaq(ctx context.Context, code string) ([]DailyHistory, error) {
 
 	//	获取记录股票历史股价的纳斯达克页面
-	html, err := downloadHtmlFromNasdaq(code)
+	html, err := downloadHtmlFromNasdaq(ctx, code)
 	if err != nil {
+		metrics.DownloadErrors.Inc("history")
 		return nil, err
 	}
 
 	//	从html中抓取股票历史股价
 	histories, err := parseHtml(code, html)
+	if err != nil {
+		metrics.ParseFailures.Inc("history")
+		return nil, err
+	}
+
+	return histories, nil
+}
+
+//	从纳斯达克更新股票复权每日历史
+func getFromNasdaq(ctx context.Context, code string, store storage.Store) ([]DailyHistory, error) {
+
+	histories, err := downloadFromNasdaq(ctx, code)
 	if err != nil {
 		return nil, err
 	}
 
 	//	保存
-	err = saveToFile(code, histories, filePath)
+	err = save(code, storage.KindDaily, histories, store)
 	if err != nil {
 		return nil, err
 	}
@@ -156,7 +260,7 @@ func getFromNasdaq(code string, filePath string) ([]DailyHistory, error) {
 }
 
 //	获取记录股票历史股价的纳斯达克页面
-func downloadHtmlFromNasdaq(code string) (string, error) {
+func downloadHtmlFromNasdaq(ctx context.Context, code string) (string, error) {
 	queryPattern := `http://www.nasdaq.com/symbol/%s/historical`
 
 	//	查询最近10年的除权股价及交易量
@@ -164,21 +268,14 @@ func downloadHtmlFromNasdaq(code string) (string, error) {
 	payload := []byte(fmt.Sprintf("10y|false|%s", code))
 	//	log.Printf("url:%s   payload:%s", url, payload)
 
-	request, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
+	fetcher, err := fetch.Default()
 	if err != nil {
 		return "", err
 	}
-	request.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	//	client.Timeout = time.Second * 60
-	response, err := client.Do(request)
-	if err != nil {
-		return "", err
-	}
-	defer response.Body.Close()
-
-	buffer, err := ioutil.ReadAll(response.Body)
+	//	最近一个交易日收盘之前缓存的页面缺少当天的数据，不能再使用
+	closed := calendar.CloseTime(calendar.LastCompletedTradingDay(time.Now()))
+	buffer, err := fetcher.PostSince(ctx, url, "application/json", payload, closed)
 	if err != nil {
 		return "", err
 	}
@@ -261,100 +358,115 @@ func parseHtml(code string, html string) ([]DailyHistory, error) {
 }
 
 //	保存股票历史
-func saveToFile(code string, histories []DailyHistory, filePath string) error {
-	//	打开文件
-	file, err := os.OpenFile(filePath, os.O_CREATE, 0x777)
+func save(code, kind string, histories []DailyHistory, store storage.Store) error {
+
+	records := make([]storage.Record, 0, len(histories))
+	for _, history := range histories {
+		records = append(records, storage.Record{
+			Date: history.Date,
+			Values: []float64{
+				history.Open,
+				history.Close,
+				history.High,
+				history.Low,
+				float64(history.Volume)},
+		})
+	}
+
+	return store.Save(code, kind, records)
+}
+
+//	获取股票每日历史
+func GetStockDailyHistory(code string) ([]DailyHistory, error) {
+
+	//	数据存储
+	store, err := storage.Default()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer file.Close()
 
-	for _, history := range histories {
+	found, err := store.Exists(code, storage.KindDaily)
+	if err != nil {
+		return nil, err
+	}
 
-		line := fmt.Sprintf("%s\t%.6f\t%.6f\t%.6f\t%.6f\t%d\t%s\n",
-			history.Date,
-			history.Open,
-			history.Close,
-			history.High,
-			history.Low,
-			history.Volume,
-			history.PrevDate)
-
-		//	将股价写入文件
-		_, err = file.WriteString(line)
-		if err != nil {
-			return err
-		}
+	if !found {
+		//	如果没有保存过就从纳斯达克获取股票复权每日历史
+		return getFromNasdaq(context.Background(), code, store)
 	}
 
-	return nil
+	return load(code, storage.KindDaily, store)
 }
 
-//	获取文件每日历史
-func GetStockDailyHistory(code, dataDir string) ([]DailyHistory, error) {
-
-	codeDailyFileName := filepath.Join(dataDir, code, dailyDataFileName)
+//	覆盖保存股票每日历史
+func SaveStockDailyHistory(code string, histories []DailyHistory) error {
 
-	_, err := os.Stat(codeDailyFileName)
-	if os.IsNotExist(err) {
-		//	如果文件不存在就从纳斯达克获取股票复权每日历史
-		return getFromNasdaq(code, codeDailyFileName)
+	//	数据存储
+	store, err := storage.Default()
+	if err != nil {
+		return err
 	}
 
-	return loadFromFile(code, codeDailyFileName)
+	return save(code, storage.KindDaily, histories, store)
 }
 
-//	从文件读取股票每日历史
-func loadFromFile(code, filePath string) ([]DailyHistory, error) {
+//	获取股票被隔离的每日历史
+func GetQuarantinedHistory(code string) ([]DailyHistory, error) {
 
-	file, err := os.Open(filePath)
+	//	数据存储
+	store, err := storage.Default()
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	histories := make([]DailyHistory, 0)
-	for scanner.Scan() {
-		parts := strings.Split(scanner.Text(), "\t")
-		if len(parts) != 7 {
-			return nil, errors.New("股票列表文件格式不正确")
-		}
+	found, err := store.Exists(code, storage.KindQuarantine)
+	if err != nil || !found {
+		return nil, err
+	}
 
-		open, err := strconv.ParseFloat(parts[1], 64)
-		if err != nil {
-			return nil, err
-		}
+	return load(code, storage.KindQuarantine, store)
+}
 
-		_close, err := strconv.ParseFloat(parts[2], 64)
-		if err != nil {
-			return nil, err
-		}
+//	覆盖保存股票被隔离的每日历史
+func SaveQuarantinedHistory(code string, histories []DailyHistory) error {
 
-		high, err := strconv.ParseFloat(parts[3], 64)
-		if err != nil {
-			return nil, err
-		}
+	//	数据存储
+	store, err := storage.Default()
+	if err != nil {
+		return err
+	}
 
-		low, err := strconv.ParseFloat(parts[4], 64)
-		if err != nil {
-			return nil, err
+	return save(code, storage.KindQuarantine, histories, store)
+}
+
+//	从存储读取股票每日历史
+func load(code, kind string, store storage.Store) ([]DailyHistory, error) {
+
+	records, err := store.Load(code, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	histories := make([]DailyHistory, 0, len(records))
+	for index, record := range records {
+		if len(record.Values) != 5 {
+			return nil, errors.New("股票每日历史格式不正确")
 		}
 
-		volume, err := strconv.ParseInt(parts[5], 10, 64)
-		if err != nil {
-			return nil, err
+		prevDate := ""
+		if index > 0 {
+			prevDate = records[index-1].Date
 		}
 
 		histories = append(histories, DailyHistory{
 			Code:     code,
-			Date:     parts[0],
-			PrevDate: parts[6],
-			Open:     open,
-			Close:    _close,
-			High:     high,
-			Low:      low,
-			Volume:   volume,
+			Date:     record.Date,
+			PrevDate: prevDate,
+			Open:     record.Values[0],
+			Close:    record.Values[1],
+			High:     record.Values[2],
+			Low:      record.Values[3],
+			Volume:   int64(record.Values[4]),
 		})
 	}
 
@@ -0,0 +1,179 @@
+package history
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/nzai/Tast/calendar"
+	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/storage"
+)
+
+const (
+	configIndicatorSection = "indicator"
+	configTimeframeKey     = "timeframe"
+	configDropPartialKey   = "droppartial"
+)
+
+//	K线周期
+type Timeframe string
+
+const (
+	Daily   Timeframe = "daily"
+	Weekly  Timeframe = "weekly"
+	Monthly Timeframe = "monthly"
+)
+
+//	解析K线周期
+func ParseTimeframe(value string) (Timeframe, error) {
+	switch Timeframe(value) {
+	case Daily, Weekly, Monthly:
+		return Timeframe(value), nil
+	case "":
+		return Daily, nil
+	}
+
+	return "", fmt.Errorf("不支持的K线周期:%s", value)
+}
+
+//	配置文件中指定的K线周期
+func DefaultTimeframe() (Timeframe, error) {
+	return ParseTimeframe(config.GetString(configIndicatorSection, configTimeframeKey, string(Daily)))
+}
+
+//	指标在该K线周期下的存储种类
+func (timeframe Timeframe) Kind(kind string) string {
+	return storage.TimeframeKind(kind, string(timeframe))
+}
+
+//	获取股票在指定周期下的K线，不完整的首尾周期按配置决定是否保留
+func GetStockBars(code string, timeframe Timeframe) ([]DailyHistory, error) {
+
+	histories, err := GetStockDailyHistory(code)
+	if err != nil {
+		return nil, err
+	}
+
+	if timeframe == Daily {
+		return histories, nil
+	}
+
+	return Resample(histories, timeframe, config.GetBool(configIndicatorSection, configDropPartialKey, true))
+}
+
+//	将按日期正序排列的每日历史合并为周线或月线
+//	每根K线的日期为该周期内最后一个有数据的交易日
+//	首尾两个周期如果没有覆盖交易日历中该周期的全部交易日则视为不完整，dropPartial为true时丢弃
+func Resample(histories []DailyHistory, timeframe Timeframe, dropPartial bool) ([]DailyHistory, error) {
+
+	if timeframe == Daily {
+		return histories, nil
+	}
+
+	bars := make([]DailyHistory, 0)
+	var bar DailyHistory
+	var first time.Time
+	key := ""
+	for _, history := range histories {
+
+		date, err := calendar.ParseDate(history.Date)
+		if err != nil {
+			return nil, err
+		}
+
+		current, err := periodKey(date, timeframe)
+		if err != nil {
+			return nil, err
+		}
+
+		if current != key {
+			if key != "" {
+				bars = append(bars, bar)
+			}
+
+			key = current
+			bar = history
+			if len(bars) == 0 {
+				first = date
+			}
+			continue
+		}
+
+		bar.Date = history.Date
+		bar.Close = history.Close
+		bar.High = math.Max(bar.High, history.High)
+		bar.Low = math.Min(bar.Low, history.Low)
+		bar.Volume += history.Volume
+	}
+
+	if key == "" {
+		return bars, nil
+	}
+	bars = append(bars, bar)
+
+	if dropPartial {
+		last, err := calendar.ParseDate(bar.Date)
+		if err != nil {
+			return nil, err
+		}
+
+		if !isPeriodEnd(last, timeframe) {
+			bars = bars[:len(bars)-1]
+		}
+
+		if len(bars) > 0 && !isPeriodStart(first, timeframe) {
+			bars = bars[1:]
+		}
+	}
+
+	//	重新链接前一根K线
+	for index := range bars {
+		if index == 0 {
+			bars[index].PrevDate = ""
+		} else {
+			bars[index].PrevDate = bars[index-1].Date
+		}
+	}
+
+	return bars, nil
+}
+
+//	日期所在周期的标识
+func periodKey(date time.Time, timeframe Timeframe) (string, error) {
+	switch timeframe {
+	case Weekly:
+		year, week := date.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week), nil
+	case Monthly:
+		return date.Format("200601"), nil
+	}
+
+	return "", fmt.Errorf("不支持的K线周期:%s", timeframe)
+}
+
+//	日期所在周期的第一天和最后一天
+func periodBounds(date time.Time, timeframe Timeframe) (time.Time, time.Time) {
+	if timeframe == Weekly {
+		offset := (int(date.Weekday()) + 6) % 7
+		start := date.AddDate(0, 0, -offset)
+		return start, start.AddDate(0, 0, 6)
+	}
+
+	start := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, -1)
+}
+
+//	是否为所在周期的第一个交易日(或更早)
+func isPeriodStart(date time.Time, timeframe Timeframe) bool {
+	start, end := periodBounds(date, timeframe)
+	days := calendar.TradingDays(start, end)
+	return len(days) == 0 || !date.After(days[0])
+}
+
+//	是否为所在周期的最后一个交易日(或更晚)
+func isPeriodEnd(date time.Time, timeframe Timeframe) bool {
+	start, end := periodBounds(date, timeframe)
+	days := calendar.TradingDays(start, end)
+	return len(days) == 0 || !date.Before(days[len(days)-1])
+}
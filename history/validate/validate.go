@@ -0,0 +1,336 @@
+package validate
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/nzai/Tast/atomicfile"
+	"github.com/nzai/Tast/calendar"
+	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/failure"
+	"github.com/nzai/Tast/history"
+	"github.com/nzai/Tast/logging"
+	"github.com/nzai/Tast/stock"
+	"github.com/nzai/Tast/storage"
+)
+
+const (
+	configSection       = "validate"
+	configQuarantineKey = "quarantine"
+	configMaxReturnKey  = "maxreturn"
+	configMaxGapKey     = "maxgap"
+	reportFileName      = "Validate.txt"
+)
+
+//	问题的严重程度
+type Severity int
+
+const (
+	Warning Severity = iota //	只报告
+	Error                   //	需要隔离
+)
+
+func (severity Severity) String() string {
+	if severity == Error {
+		return "ERROR"
+	}
+
+	return "WARN"
+}
+
+//	检查规则
+const (
+	RuleNonPositive  = "NonPositive"  //	价格不为正或成交量为负
+	RuleInconsistent = "Inconsistent" //	High、Low与Open、Close不一致
+	RuleDuplicate    = "Duplicate"    //	日期重复
+	RuleUnsorted     = "Unsorted"     //	日期未按正序排列
+	RuleGap          = "Gap"          //	缺失交易日
+	RuleExtremeMove  = "ExtremeMove"  //	日收益率异常
+)
+
+//	发现的问题
+type Issue struct {
+	Date     string
+	Rule     string
+	Severity Severity
+	Message  string
+}
+
+//	一只股票的检查报告
+type Report struct {
+	Code        string
+	Rows        int
+	Issues      []Issue
+	Quarantined []history.DailyHistory
+}
+
+//	检查参数
+type Options struct {
+	MaxReturn float64 //	日收益率绝对值超过此值时报告
+	MaxGap    int     //	两条记录之间允许缺失的交易日数
+}
+
+//	从配置文件读取检查参数
+func DefaultOptions() Options {
+	return Options{
+		MaxReturn: config.GetFloat64(configSection, configMaxReturnKey, 0.5),
+		MaxGap:    config.GetInt(configSection, configMaxGapKey, 0),
+	}
+}
+
+//	检查所有股票的每日历史，按配置隔离有问题的记录
+func UpdateAll(ctx context.Context) error {
+
+	codes, err := stock.GetCodes()
+	if err != nil {
+		return err
+	}
+
+	return Update(ctx, codes)
+}
+
+//	检查指定股票的历史，汇总报告只包含这些股票
+func Update(ctx context.Context, codes []string) error {
+
+	logger := logging.Stage("validate")
+	logger.Info("开始检查股票历史", "codes", len(codes))
+
+	dataDir, err := config.GetDataDir()
+	if err != nil {
+		return err
+	}
+
+	options := DefaultOptions()
+	quarantine := config.GetBool(configSection, configQuarantineKey, false)
+
+	//	汇总报告只包含检查成功的股票
+	failures := failure.New("validate")
+	reports := make([]*Report, 0, len(codes))
+	for _, code := range codes {
+		//	严格模式下发生错误或被取消后不再处理其他股票
+		if failures.Stopped() || ctx.Err() != nil {
+			break
+		}
+
+		report, err := updateStock(code, dataDir, options, quarantine)
+		failures.Record(code, err)
+		if err == nil {
+			reports = append(reports, report)
+		}
+	}
+
+	if ctx.Err() != nil {
+		logger.Warn("股票历史检查被取消")
+		return ctx.Err()
+	}
+
+	err = saveSummary(reports, filepath.Join(dataDir, reportFileName))
+	if err != nil {
+		return err
+	}
+
+	logger.Info("股票历史检查结束")
+
+	return failures.Err()
+}
+
+//	检查一只股票
+func updateStock(code, dataDir string, options Options, quarantine bool) (*Report, error) {
+
+	histories, err := history.GetStockDailyHistory(code)
+	if err != nil {
+		return nil, err
+	}
+
+	report, cleaned := Check(code, histories, options)
+
+	err = saveReport(report, filepath.Join(dataDir, code, reportFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	if !quarantine || len(report.Quarantined) == 0 {
+		return report, nil
+	}
+
+	logging.Stage("validate").Warn("历史记录被隔离", "code", code, "count", len(report.Quarantined))
+
+	//	追加到已隔离的记录中
+	quarantined, err := history.GetQuarantinedHistory(code)
+	if err != nil {
+		return nil, err
+	}
+
+	quarantined = append(quarantined, report.Quarantined...)
+	sort.Stable(history.StockDailyHistories(quarantined))
+
+	err = history.SaveQuarantinedHistory(code, quarantined)
+	if err != nil {
+		return nil, err
+	}
+
+	err = history.SaveStockDailyHistory(code, cleaned)
+	if err != nil {
+		return nil, err
+	}
+
+	//	已经计算的指标基于隔离前的数据，需要重新计算
+	store, err := storage.Default()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, kind := range storage.IndicatorKinds() {
+		err = store.Remove(code, kind)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+//	检查每日历史，返回报告以及去除了错误记录并按日期排序的历史
+func Check(code string, histories []history.DailyHistory, options Options) (*Report, []history.DailyHistory) {
+
+	report := &Report{Code: code, Rows: len(histories), Issues: make([]Issue, 0)}
+	add := func(date, rule string, severity Severity, format string, args ...interface{}) {
+		report.Issues = append(report.Issues, Issue{
+			Date:     date,
+			Rule:     rule,
+			Severity: severity,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	//	日期顺序
+	sorted := make([]history.DailyHistory, len(histories))
+	copy(sorted, histories)
+	for index := 1; index < len(sorted); index++ {
+		if sorted[index].Date < sorted[index-1].Date {
+			add(sorted[index].Date, RuleUnsorted, Warning, "日期%s排在%s之后", sorted[index].Date, sorted[index-1].Date)
+		}
+	}
+	sort.Stable(history.StockDailyHistories(sorted))
+
+	cleaned := make([]history.DailyHistory, 0, len(sorted))
+	report.Quarantined = make([]history.DailyHistory, 0)
+	for index, h := range sorted {
+
+		bad := false
+		if index > 0 && h.Date == sorted[index-1].Date {
+			add(h.Date, RuleDuplicate, Error, "日期重复")
+			bad = true
+		}
+
+		if h.Open <= 0 || h.Close <= 0 || h.High <= 0 || h.Low <= 0 || h.Volume < 0 {
+			add(h.Date, RuleNonPositive, Error, "Open=%.6f Close=%.6f High=%.6f Low=%.6f Volume=%d", h.Open, h.Close, h.High, h.Low, h.Volume)
+			bad = true
+		} else if h.High < h.Low || h.High < math.Max(h.Open, h.Close) || h.Low > math.Min(h.Open, h.Close) {
+			add(h.Date, RuleInconsistent, Error, "Open=%.6f Close=%.6f High=%.6f Low=%.6f", h.Open, h.Close, h.High, h.Low)
+			bad = true
+		}
+
+		if bad {
+			report.Quarantined = append(report.Quarantined, h)
+			continue
+		}
+
+		if len(cleaned) > 0 {
+			prev := cleaned[len(cleaned)-1]
+
+			//	按照交易所日历判断缺失的交易日
+			missing, err := calendar.MissingTradingDays(prev.Date, h.Date)
+			if err == nil && missing > options.MaxGap {
+				add(h.Date, RuleGap, Warning, "与前一交易日%s之间缺失%d个交易日", prev.Date, missing)
+			}
+
+			change := h.Close/prev.Close - 1
+			if options.MaxReturn > 0 && math.Abs(change) > options.MaxReturn {
+				add(h.Date, RuleExtremeMove, Warning, "收盘价从%.6f变为%.6f，变动%.2f%%", prev.Close, h.Close, change*100)
+			}
+		}
+
+		cleaned = append(cleaned, h)
+	}
+
+	//	重新链接前一交易日
+	for index := range cleaned {
+		if index == 0 {
+			cleaned[index].PrevDate = ""
+		} else {
+			cleaned[index].PrevDate = cleaned[index-1].Date
+		}
+	}
+
+	return report, cleaned
+}
+
+//	保存一只股票的检查报告
+func saveReport(report *Report, filePath string) error {
+
+	//	使用bolt等存储时股票目录不一定存在
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return err
+	}
+
+	file, err := atomicfile.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	fmt.Fprintf(writer, "Code = %s\n", report.Code)
+	fmt.Fprintf(writer, "Rows = %d\n", report.Rows)
+	fmt.Fprintf(writer, "Issues = %d\n", len(report.Issues))
+	fmt.Fprintf(writer, "Quarantined = %d\n", len(report.Quarantined))
+	for _, issue := range report.Issues {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", issue.Date, issue.Severity, issue.Rule, issue.Message)
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	return file.Commit()
+}
+
+//	保存所有股票的检查汇总
+func saveSummary(reports []*Report, filePath string) error {
+
+	file, err := atomicfile.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for _, report := range reports {
+
+		counts := make(map[string]int)
+		for _, issue := range report.Issues {
+			counts[issue.Rule]++
+		}
+
+		fmt.Fprintf(writer, "%s\tRows = %d\tQuarantined = %d", report.Code, report.Rows, len(report.Quarantined))
+		for _, rule := range []string{RuleNonPositive, RuleInconsistent, RuleDuplicate, RuleUnsorted, RuleGap, RuleExtremeMove} {
+			fmt.Fprintf(writer, "\t%s = %d", rule, counts[rule])
+		}
+		fmt.Fprintln(writer)
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	return file.Commit()
+}
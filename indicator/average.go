@@ -0,0 +1,123 @@
+package indicator
+
+import (
+	"math"
+
+	"github.com/nzai/Tast/history"
+)
+
+//	简单移动平均
+type SMA struct{}
+
+func (SMA) Name() string      { return "SMA" }
+func (SMA) Columns() []string { return []string{"sma"} }
+func (SMA) Peroids() []int    { return defaultPeroids() }
+
+func (SMA) Calculate(histories []history.DailyHistory, peroid int) ([]Point, error) {
+	return toPoints(histories, peroid, sma(closes(histories), peroid)), nil
+}
+
+//	指数移动平均
+type EMA struct{}
+
+func (EMA) Name() string      { return "EMA" }
+func (EMA) Columns() []string { return []string{"ema"} }
+func (EMA) Peroids() []int    { return defaultPeroids() }
+
+func (EMA) Calculate(histories []history.DailyHistory, peroid int) ([]Point, error) {
+	return toPoints(histories, peroid, ema(closes(histories), peroid)), nil
+}
+
+//	线性加权移动平均
+type WMA struct{}
+
+func (WMA) Name() string      { return "WMA" }
+func (WMA) Columns() []string { return []string{"wma"} }
+func (WMA) Peroids() []int    { return defaultPeroids() }
+
+func (WMA) Calculate(histories []history.DailyHistory, peroid int) ([]Point, error) {
+	return toPoints(histories, peroid, wma(closes(histories), peroid)), nil
+}
+
+//	简单移动平均，前peroid-1个数值为NaN，输入中的NaN会顺延
+func sma(values []float64, peroid int) []float64 {
+
+	result := nans(len(values))
+	var sum float64
+	count := 0
+	for index, value := range values {
+		if math.IsNaN(value) {
+			sum, count = 0, 0
+			continue
+		}
+
+		sum += value
+		count++
+		if count > peroid {
+			sum -= values[index-peroid]
+			count = peroid
+		}
+
+		if count == peroid {
+			result[index] = sum / float64(peroid)
+		}
+	}
+
+	return result
+}
+
+//	指数移动平均，以前peroid个数值的简单平均作为初始值，输入中的NaN会顺延
+func ema(values []float64, peroid int) []float64 {
+	return smooth(values, peroid, 2/(float64(peroid)+1))
+}
+
+//	Wilder平滑，以前peroid个数值的简单平均作为初始值，输入中的NaN会顺延
+func wilder(values []float64, peroid int) []float64 {
+	return smooth(values, peroid, 1/float64(peroid))
+}
+
+//	按照平滑系数alpha递推，输入中的NaN会中断递推，之后以peroid个数值的简单平均重新开始
+func smooth(values []float64, peroid int, alpha float64) []float64 {
+
+	result := nans(len(values))
+	var sum, prev float64
+	count := 0
+	for index, value := range values {
+		if math.IsNaN(value) {
+			sum, count = 0, 0
+			continue
+		}
+
+		if count < peroid {
+			sum += value
+			count++
+			if count == peroid {
+				prev = sum / float64(peroid)
+				result[index] = prev
+			}
+			continue
+		}
+
+		prev += alpha * (value - prev)
+		result[index] = prev
+	}
+
+	return result
+}
+
+//	线性加权移动平均，越近的数值权重越大
+func wma(values []float64, peroid int) []float64 {
+
+	result := nans(len(values))
+	denominator := float64(peroid*(peroid+1)) / 2
+	for index := peroid - 1; index < len(values); index++ {
+		var sum float64
+		for offset := 0; offset < peroid; offset++ {
+			sum += values[index-offset] * float64(peroid-offset)
+		}
+
+		result[index] = sum / denominator
+	}
+
+	return result
+}
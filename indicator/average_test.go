@@ -0,0 +1,72 @@
+package indicator
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/nzai/Tast/history"
+)
+
+//	先上涨，中间一段价格完全不变，之后再上涨
+func flatHistories() []history.DailyHistory {
+
+	histories := make([]history.DailyHistory, 0, 90)
+	price := 10.0
+	for index := 0; index < 90; index++ {
+		if index < 30 || index >= 60 {
+			price += 0.1 + float64(index%3)*0.05
+		}
+
+		high, low := price+0.2, price-0.2
+		if index >= 30 && index < 60 {
+			high, low = price, price
+		}
+
+		histories = append(histories, history.DailyHistory{
+			Code:  "AAA",
+			Date:  fmt.Sprintf("D%03d", index),
+			Open:  price,
+			Close: price,
+			High:  high,
+			Low:   low,
+		})
+	}
+
+	return histories
+}
+
+func TestSmoothReseed(t *testing.T) {
+
+	values := []float64{math.NaN(), 1, 2, 3, 4, math.NaN(), 6, 7, 8, 9}
+	result := wilder(values, 3)
+
+	//	开头和中断后的前peroid-1个数值为NaN，之后以简单平均重新开始
+	expected := []float64{math.NaN(), math.NaN(), math.NaN(), 2, 2 + (4-2)/3.0, math.NaN(), math.NaN(), math.NaN(), 7, 7 + (9-7)/3.0}
+	for index := range expected {
+		if math.IsNaN(expected[index]) != math.IsNaN(result[index]) ||
+			(!math.IsNaN(expected[index]) && math.Abs(expected[index]-result[index]) > 1e-9) {
+			t.Errorf("第%d个数值应为%v，实际为%v", index, expected[index], result[index])
+		}
+	}
+}
+
+func TestFlatPrices(t *testing.T) {
+
+	histories := flatHistories()
+	for _, indicator := range []Indicator{RSI{}, ADX{}, Keltner{Multiplier: 2}} {
+		points, err := indicator.Calculate(histories, 14)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//	价格不变的一段之后仍然有有效的数值
+		for _, point := range points[60:] {
+			for column, value := range point.Values {
+				if math.IsNaN(value) {
+					t.Fatalf("%s在%s的%s为NaN", indicator.Name(), point.Date, indicator.Columns()[column])
+				}
+			}
+		}
+	}
+}
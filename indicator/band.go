@@ -0,0 +1,77 @@
+package indicator
+
+import (
+	"math"
+
+	"github.com/nzai/Tast/history"
+)
+
+//	布林带，上下轨为中轨加减Multiplier倍标准差
+type Bollinger struct {
+	Multiplier float64
+}
+
+func (Bollinger) Name() string      { return "Bollinger" }
+func (Bollinger) Columns() []string { return []string{"middle", "upper", "lower"} }
+func (Bollinger) Peroids() []int    { return defaultPeroids() }
+
+func (bollinger Bollinger) Calculate(histories []history.DailyHistory, peroid int) ([]Point, error) {
+
+	values := closes(histories)
+	middle := sma(values, peroid)
+	upper, lower := nans(len(values)), nans(len(values))
+	for index := peroid - 1; index < len(values); index++ {
+
+		//	总体标准差
+		var sum float64
+		for _, value := range values[index-peroid+1 : index+1] {
+			sum += (value - middle[index]) * (value - middle[index])
+		}
+		deviation := math.Sqrt(sum / float64(peroid))
+
+		upper[index] = middle[index] + bollinger.Multiplier*deviation
+		lower[index] = middle[index] - bollinger.Multiplier*deviation
+	}
+
+	return toPoints(histories, peroid, middle, upper, lower), nil
+}
+
+//	肯特纳通道，中轨为收盘价的EMA，上下轨为中轨加减Multiplier倍ATR
+type Keltner struct {
+	Multiplier float64
+}
+
+func (Keltner) Name() string      { return "Keltner" }
+func (Keltner) Columns() []string { return []string{"middle", "upper", "lower"} }
+func (Keltner) Peroids() []int    { return defaultPeroids() }
+
+func (keltner Keltner) Calculate(histories []history.DailyHistory, peroid int) ([]Point, error) {
+
+	middle := ema(closes(histories), peroid)
+
+	trs := make([]float64, len(histories))
+	for index := range histories {
+		trs[index] = trueRange(histories, index)
+	}
+	atr := wilder(trs, peroid)
+
+	upper, lower := nans(len(histories)), nans(len(histories))
+	for index := range histories {
+		upper[index] = middle[index] + keltner.Multiplier*atr[index]
+		lower[index] = middle[index] - keltner.Multiplier*atr[index]
+	}
+
+	return toPoints(histories, peroid, middle, upper, lower), nil
+}
+
+//	真实波动幅度，第一天没有前收盘价，只用当天的最高最低价
+func trueRange(histories []history.DailyHistory, index int) float64 {
+
+	h := histories[index]
+	if index == 0 {
+		return h.High - h.Low
+	}
+
+	pdc := histories[index-1].Close
+	return math.Max(h.High-h.Low, math.Max(h.High-pdc, pdc-h.Low))
+}
@@ -0,0 +1,349 @@
+package indicator
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/failure"
+	"github.com/nzai/Tast/history"
+	"github.com/nzai/Tast/logging"
+	"github.com/nzai/Tast/stock"
+	"github.com/nzai/Tast/storage"
+	"github.com/nzai/Tast/workpool"
+)
+
+const (
+	configSection       = "indicator"
+	configIndicatorsKey = "indicators"
+	peroidMin           = 2
+	peroidMax           = 50
+)
+
+//	指标计算方法的版本，改变后已保存的指标需要重新计算，版本2开始平滑遇到NaN后重新开始
+const indexVersion = 2
+
+//	技术指标
+type Indicator interface {
+	//	指标名称，同时作为存储种类
+	Name() string
+	//	每日数值中各列的含义
+	Columns() []string
+	//	需要计算的周期，没有周期参数的指标只有周期0
+	Peroids() []int
+	//	根据按日期正序排列的K线计算周期为peroid的指标，返回值与K线一一对应
+	Calculate(histories []history.DailyHistory, peroid int) ([]Point, error)
+}
+
+//	指标在一个交易日的数值
+type Point struct {
+	Code   string
+	Peroid int
+	Date   string
+	Values []float64 //	与Columns一一对应
+	Valid  bool      //	窗口是否已满，未满时Values为NaN
+}
+
+//	已注册的指标
+var indicators = make(map[string]Indicator)
+
+//	注册指标，同时注册存储种类以便历史更新时清除
+func Register(indicator Indicator) {
+	indicators[strings.ToLower(indicator.Name())] = indicator
+	storage.RegisterIndicatorKind(indicator.Name())
+}
+
+func init() {
+	Register(SMA{})
+	Register(EMA{})
+	Register(WMA{})
+	Register(RSI{})
+	Register(MACD{Fast: 12, Slow: 26, Signal: 9})
+	Register(Bollinger{Multiplier: 2})
+	Register(ADX{})
+	Register(Keltner{Multiplier: 2})
+	Register(OBV{})
+}
+
+//	根据名称获取指标(不区分大小写)
+func Get(name string) (Indicator, error) {
+	indicator, found := indicators[strings.ToLower(strings.TrimSpace(name))]
+	if !found {
+		return nil, fmt.Errorf("不支持的技术指标:%s", name)
+	}
+
+	return indicator, nil
+}
+
+//	检查指标是否计算了周期为peroid的数值
+func CheckPeroid(indicator Indicator, peroid int) error {
+
+	peroids := indicator.Peroids()
+	for _, p := range peroids {
+		if p == peroid {
+			return nil
+		}
+	}
+
+	if len(peroids) == 1 && peroids[0] == 0 {
+		return fmt.Errorf("技术指标%s没有周期参数", indicator.Name())
+	}
+
+	return fmt.Errorf("技术指标%s不支持周期%d，支持的周期为%d到%d", indicator.Name(), peroid, peroids[0], peroids[len(peroids)-1])
+}
+
+//	所有已注册指标的名称
+func Names() []string {
+	names := make([]string, 0, len(indicators))
+	for _, indicator := range indicators {
+		names = append(names, indicator.Name())
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+//	配置文件中指定需要预先计算的指标
+func DefaultIndicators() ([]Indicator, error) {
+
+	list := make([]Indicator, 0)
+	for _, name := range strings.Split(config.GetString(configSection, configIndicatorsKey, ""), ",") {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+
+		indicator, err := Get(name)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, indicator)
+	}
+
+	return list, nil
+}
+
+//	更新所有股票的技术指标
+func UpdateAll(ctx context.Context) error {
+
+	//	获取所有股票
+	codes, err := stock.GetCodes()
+	if err != nil {
+		return err
+	}
+
+	return Update(ctx, codes)
+}
+
+//	更新指定股票在配置文件中指定的技术指标
+func Update(ctx context.Context, codes []string) error {
+
+	logger := logging.Stage("indicator")
+	logger.Info("开始更新技术指标", "codes", len(codes))
+
+	//	数据存储
+	store, err := storage.Default()
+	if err != nil {
+		return err
+	}
+
+	//	K线周期
+	timeframe, err := history.DefaultTimeframe()
+	if err != nil {
+		return err
+	}
+
+	list, err := DefaultIndicators()
+	if err != nil {
+		return err
+	}
+
+	failures := failure.New("indicator")
+	err = workpool.Calculate(ctx, workpool.Shared(), codes, prepare(timeframe, list, store), failures.Record, failures.Stopped)
+	if err != nil {
+		logger.Warn("技术指标更新被取消")
+		return err
+	}
+
+	logger.Info("技术指标更新完毕")
+
+	return failures.Err()
+}
+
+//	一只股票尚未用当前版本的方法计算的各个技术指标，按周期拆分计算
+func prepare(timeframe history.Timeframe, list []Indicator, store storage.Store) workpool.Prepare {
+	return func(code string) ([]workpool.Kind, error) {
+
+		//	获取股票在该周期下的K线
+		histories, err := history.GetStockBars(code, timeframe)
+		if err != nil {
+			return nil, err
+		}
+
+		kinds := make([]workpool.Kind, 0, len(list))
+		for _, indicator := range list {
+			kind := timeframe.Kind(indicator.Name())
+			found, err := storage.Current(store, code, kind, indexVersion)
+			if err != nil {
+				return nil, err
+			}
+
+			if found {
+				//	已经用当前版本的方法保存过的就跳过不重新计算
+				continue
+			}
+
+			indicator := indicator
+			kinds = append(kinds, workpool.Kind{
+				Peroids: indicator.Peroids(),
+				Calculate: func(peroid int) (interface{}, error) {
+					return indicator.Calculate(histories, peroid)
+				},
+				Save: func(results map[int]interface{}) error {
+					//	按Peroids的顺序保存
+					allPoints := make([][]Point, 0, len(results))
+					for _, peroid := range indicator.Peroids() {
+						allPoints = append(allPoints, results[peroid].([]Point))
+					}
+
+					return save(code, kind, allPoints, store)
+				},
+			})
+		}
+
+		return kinds, nil
+	}
+}
+
+//	保存一个指标各周期的数值
+func save(code, kind string, allPoints [][]Point, store storage.Store) error {
+
+	records := make([]storage.Record, 0)
+	for _, points := range allPoints {
+		for _, point := range points {
+			records = append(records, storage.Record{
+				Peroid: point.Peroid,
+				Date:   point.Date,
+				Values: point.Values,
+			})
+		}
+	}
+
+	return storage.SaveVersion(store, code, kind, records, indexVersion)
+}
+
+//	获取股票的技术指标，按周期分组
+func GetStockIndex(code string, timeframe history.Timeframe, indicator Indicator) (map[int][]Point, error) {
+
+	//	数据存储
+	store, err := storage.Default()
+	if err != nil {
+		return nil, err
+	}
+
+	kind := timeframe.Kind(indicator.Name())
+	found, err := storage.Current(store, code, kind, indexVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		//	如果没有保存过或者由旧版本的方法计算就先计算指标
+		err = workpool.CalculateStock(context.Background(), workpool.Shared(), code, prepare(timeframe, []Indicator{indicator}, store))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return load(code, kind, indicator, store)
+}
+
+//	从存储中读入指标
+func load(code, kind string, indicator Indicator, store storage.Store) (map[int][]Point, error) {
+
+	records, err := store.Load(code, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := len(indicator.Columns())
+	allIndex := make(map[int][]Point)
+	for _, record := range records {
+		if len(record.Values) != columns {
+			return nil, fmt.Errorf("%s指标格式不正确", indicator.Name())
+		}
+
+		allIndex[record.Peroid] = append(allIndex[record.Peroid], newPoint(code, record.Peroid, record.Date, record.Values...))
+	}
+
+	return allIndex, nil
+}
+
+//	新建指标数值，任意一列为NaN都视为无效
+func newPoint(code string, peroid int, date string, values ...float64) Point {
+
+	valid := true
+	for _, value := range values {
+		if math.IsNaN(value) {
+			valid = false
+			break
+		}
+	}
+
+	return Point{
+		Code:   code,
+		Peroid: peroid,
+		Date:   date,
+		Values: values,
+		Valid:  valid,
+	}
+}
+
+//	指定长度的NaN序列
+func nans(count int) []float64 {
+	values := make([]float64, count)
+	for index := range values {
+		values[index] = math.NaN()
+	}
+
+	return values
+}
+
+//	默认的周期范围
+func defaultPeroids() []int {
+	peroids := make([]int, 0, peroidMax-peroidMin+1)
+	for peroid := peroidMin; peroid <= peroidMax; peroid++ {
+		peroids = append(peroids, peroid)
+	}
+
+	return peroids
+}
+
+//	将若干列逐日数值组合为指标序列
+func toPoints(histories []history.DailyHistory, peroid int, columns ...[]float64) []Point {
+
+	points := make([]Point, 0, len(histories))
+	for index, h := range histories {
+		values := make([]float64, len(columns))
+		for column := range columns {
+			values[column] = columns[column][index]
+		}
+
+		points = append(points, newPoint(h.Code, peroid, h.Date, values...))
+	}
+
+	return points
+}
+
+//	收盘价序列
+func closes(histories []history.DailyHistory) []float64 {
+	values := make([]float64, len(histories))
+	for index, h := range histories {
+		values[index] = h.Close
+	}
+
+	return values
+}
@@ -0,0 +1,130 @@
+package indicator
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/nzai/Tast/history"
+)
+
+//	相对强弱指数(Wilder平滑)
+type RSI struct{}
+
+func (RSI) Name() string      { return "RSI" }
+func (RSI) Columns() []string { return []string{"rsi"} }
+func (RSI) Peroids() []int    { return defaultPeroids() }
+
+func (RSI) Calculate(histories []history.DailyHistory, peroid int) ([]Point, error) {
+
+	//	第一天没有涨跌
+	gains, losses := nans(len(histories)), nans(len(histories))
+	for index := 1; index < len(histories); index++ {
+		change := histories[index].Close - histories[index-1].Close
+		gains[index] = math.Max(change, 0)
+		losses[index] = math.Max(-change, 0)
+	}
+
+	averageGains, averageLosses := wilder(gains, peroid), wilder(losses, peroid)
+	rsi := nans(len(histories))
+	for index := range histories {
+		if math.IsNaN(averageGains[index]) {
+			continue
+		}
+
+		if averageLosses[index] == 0 {
+			rsi[index] = 100
+		} else {
+			rsi[index] = 100 - 100/(1+averageGains[index]/averageLosses[index])
+		}
+	}
+
+	return toPoints(histories, peroid, rsi), nil
+}
+
+//	指数平滑异同移动平均线，周期固定为Fast、Slow和Signal，保存时周期为0
+type MACD struct {
+	Fast   int
+	Slow   int
+	Signal int
+}
+
+func (MACD) Name() string      { return "MACD" }
+func (MACD) Columns() []string { return []string{"macd", "signal", "histogram"} }
+func (MACD) Peroids() []int    { return []int{0} }
+
+func (macd MACD) Calculate(histories []history.DailyHistory, peroid int) ([]Point, error) {
+
+	if macd.Fast <= 0 || macd.Fast >= macd.Slow || macd.Signal <= 0 {
+		return nil, fmt.Errorf("MACD参数不正确 fast=%d slow=%d signal=%d", macd.Fast, macd.Slow, macd.Signal)
+	}
+
+	values := closes(histories)
+	fast, slow := ema(values, macd.Fast), ema(values, macd.Slow)
+
+	line := make([]float64, len(histories))
+	for index := range line {
+		line[index] = fast[index] - slow[index]
+	}
+
+	signal := ema(line, macd.Signal)
+	histogram := make([]float64, len(histories))
+	for index := range histogram {
+		histogram[index] = line[index] - signal[index]
+	}
+
+	return toPoints(histories, peroid, line, signal, histogram), nil
+}
+
+//	平均趋向指数，同时给出+DI和-DI
+type ADX struct{}
+
+func (ADX) Name() string      { return "ADX" }
+func (ADX) Columns() []string { return []string{"adx", "plusdi", "minusdi"} }
+func (ADX) Peroids() []int    { return defaultPeroids() }
+
+func (ADX) Calculate(histories []history.DailyHistory, peroid int) ([]Point, error) {
+
+	count := len(histories)
+	trs, plusDM, minusDM := nans(count), nans(count), nans(count)
+	for index := 1; index < count; index++ {
+		h, prev := histories[index], histories[index-1]
+		trs[index] = trueRange(histories, index)
+
+		up, down := h.High-prev.High, prev.Low-h.Low
+		plusDM[index], minusDM[index] = 0, 0
+		if up > down && up > 0 {
+			plusDM[index] = up
+		}
+		if down > up && down > 0 {
+			minusDM[index] = down
+		}
+	}
+
+	//	平滑后的比值与Wilder的累加平滑相同
+	atr, plus, minus := wilder(trs, peroid), wilder(plusDM, peroid), wilder(minusDM, peroid)
+
+	plusDI, minusDI, dx := nans(count), nans(count), nans(count)
+	for index := 0; index < count; index++ {
+		if math.IsNaN(atr[index]) {
+			continue
+		}
+
+		//	价格不变时没有趋向
+		if atr[index] == 0 {
+			plusDI[index], minusDI[index], dx[index] = 0, 0, 0
+			continue
+		}
+
+		plusDI[index] = 100 * plus[index] / atr[index]
+		minusDI[index] = 100 * minus[index] / atr[index]
+
+		sum := plusDI[index] + minusDI[index]
+		if sum == 0 {
+			dx[index] = 0
+		} else {
+			dx[index] = 100 * math.Abs(plusDI[index]-minusDI[index]) / sum
+		}
+	}
+
+	return toPoints(histories, peroid, wilder(dx, peroid), plusDI, minusDI), nil
+}
@@ -0,0 +1,30 @@
+package indicator
+
+import (
+	"github.com/nzai/Tast/history"
+)
+
+//	能量潮，没有周期参数，保存时周期为0
+type OBV struct{}
+
+func (OBV) Name() string      { return "OBV" }
+func (OBV) Columns() []string { return []string{"obv"} }
+func (OBV) Peroids() []int    { return []int{0} }
+
+func (OBV) Calculate(histories []history.DailyHistory, peroid int) ([]Point, error) {
+
+	obv := make([]float64, len(histories))
+	for index := 1; index < len(histories); index++ {
+		h, prev := histories[index], histories[index-1]
+		switch {
+		case h.Close > prev.Close:
+			obv[index] = obv[index-1] + float64(h.Volume)
+		case h.Close < prev.Close:
+			obv[index] = obv[index-1] - float64(h.Volume)
+		default:
+			obv[index] = obv[index-1]
+		}
+	}
+
+	return toPoints(histories, peroid, obv), nil
+}
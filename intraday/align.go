@@ -0,0 +1,120 @@
+package intraday
+
+import (
+	"math"
+
+	"github.com/nzai/Tast/history"
+)
+
+const (
+	//	分钟K线合成的日线与每日历史之间允许的相对误差
+	alignTolerance = 0.01
+)
+
+//	分钟K线与每日历史的对应情况
+type Alignment struct {
+	Code       string
+	Matched    []string //	两者都有且价格一致的交易日
+	Mismatched []string //	两者都有但价格不一致的交易日
+	Missing    []string //	有每日历史但没有分钟K线的交易日
+	Orphans    []string //	有分钟K线但没有每日历史的交易日
+}
+
+//	将股票的分钟K线与每日历史按交易日对齐
+func Align(provider Provider, code string, histories []history.DailyHistory) (*Alignment, error) {
+
+	dates, err := provider.Dates(code)
+	if err != nil {
+		return nil, err
+	}
+
+	available := make(map[string]bool, len(dates))
+	for _, date := range dates {
+		available[date] = true
+	}
+
+	alignment := &Alignment{
+		Code:       code,
+		Matched:    make([]string, 0),
+		Mismatched: make([]string, 0),
+		Missing:    make([]string, 0),
+		Orphans:    make([]string, 0),
+	}
+
+	daily := make(map[string]bool, len(histories))
+	for _, h := range histories {
+		daily[h.Date] = true
+
+		if !available[h.Date] {
+			alignment.Missing = append(alignment.Missing, h.Date)
+			continue
+		}
+
+		bars, err := provider.GetMinuteBars(code, h.Date)
+		if err != nil {
+			return nil, err
+		}
+
+		if Consistent(Aggregate(bars), h) {
+			alignment.Matched = append(alignment.Matched, h.Date)
+		} else {
+			alignment.Mismatched = append(alignment.Mismatched, h.Date)
+		}
+	}
+
+	for _, date := range dates {
+		if !daily[date] {
+			alignment.Orphans = append(alignment.Orphans, date)
+		}
+	}
+
+	return alignment, nil
+}
+
+//	将一个交易日的分钟K线合成为日线
+func Aggregate(bars []MinuteBar) history.DailyHistory {
+
+	if len(bars) == 0 {
+		return history.DailyHistory{}
+	}
+
+	daily := history.DailyHistory{
+		Code:  bars[0].Code,
+		Date:  bars[0].Date,
+		Open:  bars[0].Open,
+		Close: bars[len(bars)-1].Close,
+		High:  bars[0].High,
+		Low:   bars[0].Low,
+	}
+
+	for _, bar := range bars {
+		daily.High = math.Max(daily.High, bar.High)
+		daily.Low = math.Min(daily.Low, bar.Low)
+		daily.Volume += bar.Volume
+	}
+
+	return daily
+}
+
+//	分钟K线合成的日线与每日历史的价格是否一致
+//	每日历史是复权价格，这里只比较振幅区间是否大致吻合
+func Consistent(aggregated, daily history.DailyHistory) bool {
+
+	if aggregated.High <= 0 || daily.High <= 0 {
+		return false
+	}
+
+	//	复权因子
+	factor := daily.Close / aggregated.Close
+	for _, pair := range [][2]float64{
+		{aggregated.Open * factor, daily.Open},
+		{aggregated.High * factor, daily.High},
+		{aggregated.Low * factor, daily.Low},
+	} {
+		if math.Abs(pair[0]/pair[1]-1) > alignTolerance {
+			return false
+		}
+	}
+
+	return true
+}
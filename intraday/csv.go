@@ -0,0 +1,188 @@
+package intraday
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nzai/Tast/calendar"
+	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/logging"
+)
+
+const (
+	configSection        = "intraday"
+	configRegularOnlyKey = "regularonly"
+)
+
+//	支持的时间戳格式，没有时区的时间按交易所所在时区解释
+var timestampLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	"20060102 15:04:05",
+	"20060102 150405",
+	"01/02/2006 15:04:05",
+	"01/02/2006 15:04",
+}
+
+//	从常见的第三方CSV文件导入一只股票的分钟K线，返回导入的K线数量
+//	支持以下格式(自动识别，可以有标题行):
+//	1. 时间戳,Open,High,Low,Close,Volume	时间戳为上面列出的格式或者Unix秒/毫秒
+//	2. 日期,时间,Open,High,Low,Close,Volume	如 01/02/2006,09:30,... 或 2006-01-02,0930,...
+func ImportCSV(code, filePath string) (int, error) {
+
+	dataDir, err := config.GetDataDir()
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	bars, err := ParseCSV(code, file)
+	if err != nil {
+		return 0, fmt.Errorf("解析分钟K线文件%s时发生错误:%v", filePath, err)
+	}
+
+	//	默认只保留常规交易时段
+	if config.GetBool(configSection, configRegularOnlyKey, true) {
+		regular := make([]MinuteBar, 0, len(bars))
+		for _, bar := range bars {
+			if IsRegularSession(bar) {
+				regular = append(regular, bar)
+			}
+		}
+
+		logging.Stage("intraday").Info("分钟K线不在常规交易时段内", "code", code, "count", len(bars)-len(regular))
+		bars = regular
+	}
+
+	return len(bars), Save(dataDir, code, bars)
+}
+
+//	解析CSV格式的分钟K线
+func ParseCSV(code string, reader io.Reader) ([]MinuteBar, error) {
+
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+
+	bars := make([]MinuteBar, 0)
+	for line := 1; ; line++ {
+		fields, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		bar, err := parseFields(code, fields)
+		if err != nil {
+			//	第一行可能是标题
+			if line == 1 {
+				continue
+			}
+
+			return nil, fmt.Errorf("第%d行:%v", line, err)
+		}
+
+		bars = append(bars, bar)
+	}
+
+	return bars, nil
+}
+
+//	解析一行
+func parseFields(code string, fields []string) (MinuteBar, error) {
+
+	var timestamp time.Time
+	var err error
+	switch len(fields) {
+	case 6:
+		timestamp, err = parseTimestamp(fields[0])
+	case 7:
+		timestamp, err = parseDateTime(fields[0], fields[1])
+	default:
+		return MinuteBar{}, fmt.Errorf("字段数量%d不正确", len(fields))
+	}
+	if err != nil {
+		return MinuteBar{}, err
+	}
+
+	values := fields[len(fields)-5:]
+	prices := make([]float64, 4)
+	for index := range prices {
+		prices[index], err = strconv.ParseFloat(values[index], 64)
+		if err != nil {
+			return MinuteBar{}, err
+		}
+	}
+
+	volume, err := strconv.ParseFloat(values[4], 64)
+	if err != nil {
+		return MinuteBar{}, err
+	}
+
+	timestamp = timestamp.In(calendar.Location())
+	return MinuteBar{
+		Code:   code,
+		Date:   calendar.FormatDate(timestamp),
+		Time:   timestamp.Format(timeLayout),
+		Open:   prices[0],
+		High:   prices[1],
+		Low:    prices[2],
+		Close:  prices[3],
+		Volume: int64(volume),
+	}, nil
+}
+
+//	解析单个字段的时间戳
+func parseTimestamp(value string) (time.Time, error) {
+
+	//	Unix时间戳
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err == nil {
+		if number > 1e11 {
+			return time.Unix(number/1000, number%1000*int64(time.Millisecond)), nil
+		}
+
+		return time.Unix(number, 0), nil
+	}
+
+	for _, layout := range timestampLayouts {
+		timestamp, err := time.ParseInLocation(layout, value, calendar.Location())
+		if err == nil {
+			return timestamp, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("无法识别的时间:%s", value)
+}
+
+//	解析分开的日期和时间字段
+func parseDateTime(date, clock string) (time.Time, error) {
+
+	clock = strings.Replace(clock, ":", "", -1)
+	if len(clock) == 6 {
+		clock = clock[:4]
+	}
+
+	for _, layout := range []string{"01/02/2006", "2006-01-02", "20060102"} {
+		timestamp, err := time.ParseInLocation(layout+" 1504", date+" "+clock, calendar.Location())
+		if err == nil {
+			return timestamp, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("无法识别的时间:%s %s", date, clock)
+}
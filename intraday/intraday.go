@@ -0,0 +1,228 @@
+package intraday
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nzai/Tast/atomicfile"
+	"github.com/nzai/Tast/calendar"
+	"github.com/nzai/Tast/config"
+)
+
+const (
+	intradayDirName = "Intraday"
+	fileExtension   = ".txt"
+	timeLayout      = "1504"
+)
+
+//	分钟K线，时间为交易所所在时区的开始时刻
+type MinuteBar struct {
+	Code   string
+	Date   string //	yyyymmdd
+	Time   string //	hhmm
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	Volume int64
+}
+
+//	分钟K线的开始时刻
+func (bar MinuteBar) Timestamp() (time.Time, error) {
+	return time.ParseInLocation("20060102"+timeLayout, bar.Date+bar.Time, calendar.Location())
+}
+
+type MinuteBars []MinuteBar
+
+func (slice MinuteBars) Len() int {
+	return len(slice)
+}
+
+func (slice MinuteBars) Less(i, j int) bool {
+	if slice[i].Date == slice[j].Date {
+		return slice[i].Time < slice[j].Time
+	}
+
+	return slice[i].Date < slice[j].Date
+}
+
+func (slice MinuteBars) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+//	分钟K线数据源
+type Provider interface {
+	//	获取股票某个交易日的分钟K线，没有数据时返回nil
+	GetMinuteBars(code, date string) ([]MinuteBar, error)
+	//	股票有分钟K线的所有交易日
+	Dates(code string) ([]string, error)
+}
+
+//	保存在每只股票目录下、按交易日分文件的本地分钟K线
+//	文件为 dataDir/code/Intraday/yyyymmdd.txt，每行为 时间 Open High Low Close Volume
+type localProvider struct {
+	dataDir string
+}
+
+//	新建本地分钟K线数据源
+func NewLocalProvider(dataDir string) Provider {
+	return &localProvider{dataDir: dataDir}
+}
+
+//	根据配置文件中的数据目录新建本地分钟K线数据源
+func Default() (Provider, error) {
+
+	dataDir, err := config.GetDataDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewLocalProvider(dataDir), nil
+}
+
+//	分钟K线目录
+func (provider *localProvider) dir(code string) string {
+	return filepath.Join(provider.dataDir, code, intradayDirName)
+}
+
+func (provider *localProvider) GetMinuteBars(code, date string) ([]MinuteBar, error) {
+
+	file, err := os.Open(filepath.Join(provider.dir(code), date+fileExtension))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	bars := make([]MinuteBar, 0)
+	for scanner.Scan() {
+		parts := strings.Split(scanner.Text(), "\t")
+		if len(parts) != 6 {
+			return nil, errors.New("分钟K线文件格式不正确")
+		}
+
+		values := make([]float64, 4)
+		for index := range values {
+			values[index], err = strconv.ParseFloat(parts[index+1], 64)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		volume, err := strconv.ParseInt(parts[5], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		bars = append(bars, MinuteBar{
+			Code:   code,
+			Date:   date,
+			Time:   parts[0],
+			Open:   values[0],
+			High:   values[1],
+			Low:    values[2],
+			Close:  values[3],
+			Volume: volume,
+		})
+	}
+
+	return bars, scanner.Err()
+}
+
+func (provider *localProvider) Dates(code string) ([]string, error) {
+
+	names, err := filepath.Glob(filepath.Join(provider.dir(code), "*"+fileExtension))
+	if err != nil {
+		return nil, err
+	}
+
+	dates := make([]string, 0, len(names))
+	for _, name := range names {
+		dates = append(dates, strings.TrimSuffix(filepath.Base(name), fileExtension))
+	}
+	sort.Strings(dates)
+
+	return dates, nil
+}
+
+//	保存一只股票的分钟K线，每个交易日的文件整体覆盖
+func Save(dataDir, code string, bars []MinuteBar) error {
+
+	dir := filepath.Join(dataDir, code, intradayDirName)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	sorted := make([]MinuteBar, len(bars))
+	copy(sorted, bars)
+	sort.Stable(MinuteBars(sorted))
+
+	start := 0
+	for index := 1; index <= len(sorted); index++ {
+		if index < len(sorted) && sorted[index].Date == sorted[start].Date {
+			continue
+		}
+
+		err = saveDay(filepath.Join(dir, sorted[start].Date+fileExtension), sorted[start:index])
+		if err != nil {
+			return err
+		}
+
+		start = index
+	}
+
+	return nil
+}
+
+//	保存一个交易日的分钟K线
+func saveDay(filePath string, bars []MinuteBar) error {
+
+	file, err := atomicfile.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for _, bar := range bars {
+		_, err = fmt.Fprintf(writer, "%s\t%.6f\t%.6f\t%.6f\t%.6f\t%d\n",
+			bar.Time,
+			bar.Open,
+			bar.High,
+			bar.Low,
+			bar.Close,
+			bar.Volume)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	return file.Commit()
+}
+
+//	是否在常规交易时段内(考虑提前收盘)
+func IsRegularSession(bar MinuteBar) bool {
+
+	timestamp, err := bar.Timestamp()
+	if err != nil || !calendar.IsTradingDay(timestamp) {
+		return false
+	}
+
+	return !timestamp.Before(calendar.OpenTime(timestamp)) && timestamp.Before(calendar.CloseTime(timestamp))
+}
@@ -0,0 +1,177 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nzai/Tast/config"
+)
+
+const (
+	configPathSection   = "path"
+	configPathKey       = "logpath"
+	configSection       = "log"
+	configFormatKey     = "format"
+	configLevelKey      = "level"
+	configConsoleKey    = "console"
+	configMaxSizeKey    = "maxsize"
+	configMaxBackupsKey = "maxbackups"
+	defaultFileName     = "main.log"
+	formatJSON          = "json"
+	formatLogfmt        = "logfmt"
+	consoleAuto         = "auto"
+	defaultMaxSize      = 100 //	MB
+	defaultMaxBackups   = 5
+	bytesPerMegabyte    = 1 << 20
+)
+
+var mirrored bool
+
+//	根据配置文件设置日志，返回关闭日志文件的函数
+//	日志以JSON或logfmt格式写入文件，超过maxsize(MB)时轮转，保留maxbackups个旧文件；
+//	交互运行时同时以文本格式输出到控制台。标准库log的输出也会以Info级别写入
+func Setup() (func() error, error) {
+
+	level, err := parseLevel(config.GetString(configSection, configLevelKey, "info"))
+	if err != nil {
+		return nil, err
+	}
+
+	//	日志文件路径
+	logPath := config.GetString(configPathSection, configPathKey, defaultFileName)
+	logDir := filepath.Dir(logPath)
+	_, err = os.Stat(logDir)
+	if os.IsNotExist(err) {
+		err = os.Mkdir(logDir, 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := openRotatingFile(logPath,
+		int64(config.GetInt(configSection, configMaxSizeKey, defaultMaxSize))*bytesPerMegabyte,
+		config.GetInt(configSection, configMaxBackupsKey, defaultMaxBackups))
+	if err != nil {
+		return nil, err
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch format := strings.ToLower(config.GetString(configSection, configFormatKey, formatLogfmt)); format {
+	case formatJSON:
+		handler = slog.NewJSONHandler(file, options)
+	case formatLogfmt:
+		handler = slog.NewTextHandler(file, options)
+	default:
+		file.Close()
+		return nil, fmt.Errorf("不支持的日志格式:%s", format)
+	}
+
+	console, err := consoleEnabled(config.GetString(configSection, configConsoleKey, consoleAuto))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if console {
+		handler = fanout{handler, slog.NewTextHandler(os.Stderr, options)}
+	}
+	mirrored = console
+
+	slog.SetDefault(slog.New(handler))
+
+	return file.Close, nil
+}
+
+//	日志是否同时输出到控制台
+func Mirrored() bool {
+	return mirrored
+}
+
+//	某个阶段的日志，记录中带有stage字段
+func Stage(name string) *slog.Logger {
+	return slog.Default().With("stage", name)
+}
+
+//	解析日志级别
+func parseLevel(value string) (slog.Level, error) {
+
+	var level slog.Level
+	err := level.UnmarshalText([]byte(strings.TrimSpace(value)))
+	if err != nil {
+		return level, fmt.Errorf("不支持的日志级别:%s", value)
+	}
+
+	return level, nil
+}
+
+//	是否输出到控制台，auto表示标准错误是终端时输出
+func consoleEnabled(value string) (bool, error) {
+
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case consoleAuto, "":
+		info, err := os.Stderr.Stat()
+		if err != nil {
+			return false, nil
+		}
+
+		return info.Mode()&os.ModeCharDevice != 0, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+
+	return false, fmt.Errorf("配置%s.%s只能是auto、true或false", configSection, configConsoleKey)
+}
+
+//	同时输出到多个Handler
+type fanout []slog.Handler
+
+func (handlers fanout) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range handlers {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (handlers fanout) Handle(ctx context.Context, record slog.Record) error {
+	var result error
+	for _, handler := range handlers {
+		if !handler.Enabled(ctx, record.Level) {
+			continue
+		}
+
+		err := handler.Handle(ctx, record.Clone())
+		if err != nil && result == nil {
+			result = err
+		}
+	}
+
+	return result
+}
+
+func (handlers fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	list := make(fanout, 0, len(handlers))
+	for _, handler := range handlers {
+		list = append(list, handler.WithAttrs(attrs))
+	}
+
+	return list
+}
+
+func (handlers fanout) WithGroup(name string) slog.Handler {
+	list := make(fanout, 0, len(handlers))
+	for _, handler := range handlers {
+		list = append(list, handler.WithGroup(name))
+	}
+
+	return list
+}
@@ -0,0 +1,114 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+//	按大小轮转的日志文件，超过maxSize时将当前文件改名为path.1，原有的path.1改名为path.2，依此类推
+type rotatingFile struct {
+	mutex   sync.Mutex
+	path    string
+	maxSize int64 //	为0时不轮转
+	backups int   //	保留的旧文件数量
+	file    *os.File
+	size    int64
+}
+
+func openRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+
+	file := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	err := file.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+//	以追加方式打开日志文件
+func (file *rotatingFile) open() error {
+
+	f, err := os.OpenFile(file.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	file.file, file.size = f, info.Size()
+
+	return nil
+}
+
+func (file *rotatingFile) Write(buffer []byte) (int, error) {
+
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	if file.maxSize > 0 && file.size > 0 && file.size+int64(len(buffer)) > file.maxSize {
+		err := file.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := file.file.Write(buffer)
+	file.size += int64(n)
+
+	return n, err
+}
+
+//	轮转日志文件
+func (file *rotatingFile) rotate() error {
+
+	err := file.file.Close()
+	if err != nil {
+		return err
+	}
+
+	if file.backups <= 0 {
+		err = os.Remove(file.path)
+	} else {
+		//	删除最旧的文件，其余依次后移，旧文件还不够多时不存在是正常的
+		oldest := fmt.Sprintf("%s.%d", file.path, file.backups)
+		err = os.Remove(oldest)
+		if err != nil && !os.IsNotExist(err) {
+			reportError("删除旧日志文件", oldest, err)
+		}
+
+		for index := file.backups - 1; index >= 1; index-- {
+			backup := fmt.Sprintf("%s.%d", file.path, index)
+			err = os.Rename(backup, fmt.Sprintf("%s.%d", file.path, index+1))
+			if err != nil && !os.IsNotExist(err) {
+				reportError("日志文件改名", backup, err)
+			}
+		}
+
+		err = os.Rename(file.path, file.path+".1")
+	}
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return file.open()
+}
+
+//	轮转时持有日志文件的锁，不能再写入日志，只能输出到标准错误
+func reportError(action, path string, err error) {
+	fmt.Fprintf(os.Stderr, "%s%s时发生错误:%v\n", action, path, err)
+}
+
+func (file *rotatingFile) Close() error {
+
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	return file.file.Close()
+}
@@ -1,95 +1,148 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
 
 	"github.com/nzai/Tast/config"
-	"github.com/nzai/Tast/history"
-	"github.com/nzai/Tast/peroidexterma"
-	"github.com/nzai/Tast/stock"
-	"github.com/nzai/Tast/trading"
-	"github.com/nzai/Tast/turtle"
+	"github.com/nzai/Tast/logging"
+	"github.com/nzai/Tast/metrics"
+	"github.com/nzai/Tast/storage"
 )
 
 const (
-	configFileName           = "config.ini"
-	configLogSection         = "path"
-	configLogKey             = "logpath"
-	configLogDefaultFileName = "main.log"
+	configFileName = "config.ini"
 )
 
 func main() {
 
-	//	当前目录
-	root := filepath.Dir(os.Args[0])
-	filename := filepath.Join(root, configFileName)
+	//	全局参数，必须写在命令之前
+	flags := flag.NewFlagSet("tast", flag.ExitOnError)
+	configFile := flags.String("config", "", "配置文件路径，默认为程序所在目录下的config.ini")
+	overrides := make(settings, 0)
+	flags.Var(&overrides, "set", "覆盖配置文件中的值，格式为 section.key=value，可以指定多次")
+	flags.Usage = func() {
+		usage(flags)
+	}
+	flags.Parse(os.Args[1:])
 
-	//	使用所有cpu
-	//	runtime.GOMAXPROCS(runtime.NumCPU() - 1)
+	command, args := findCommand(flags.Args())
+	if command == nil {
+		usage(flags)
+		os.Exit(2)
+	}
 
 	//	读取配置文件
+	filename := *configFile
+	if filename == "" {
+		filename = filepath.Join(filepath.Dir(os.Args[0]), configFileName)
+	}
+
 	err := config.SetConfigFile(filename)
 	if err != nil {
 		log.Fatal(err)
 		return
 	}
 
-	//	日志文件路径
-	logPath := config.GetString(configLogSection, configLogKey, configLogDefaultFileName)
-	logDir := filepath.Dir(logPath)
-	_, err = os.Stat(logDir)
-	if os.IsNotExist(err) {
-		err = os.Mkdir(logDir, 0x777)
-		if err != nil {
-			log.Fatal(err)
-			return
-		}
-	}
-
-	file, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0x777)
+	//	命令行指定的配置优先
+	err = overrides.apply()
 	if err != nil {
 		log.Fatal(err)
 		return
 	}
-	defer file.Close()
 
-	//	设置日志输出文件
-	log.SetOutput(file)
-
-	//	更新股票信息
-	err = stock.UpdateAll()
+	//	设置日志
+	closeLog, err := logging.Setup()
 	if err != nil {
-		log.Fatalf("更新股票列表发生错误:%v", err)
+		log.Fatal(err)
 		return
 	}
+	defer closeLog()
 
-	//	更新所有股票的历史
-	err = history.UpdateAll()
-	if err != nil {
-		log.Fatalf("更新股票历史发生错误:%v", err)
-		return
+	//	关闭数据存储
+	defer storage.Close()
+
+	//	配置了监听地址时提供监控指标
+	metrics.Start()
+
+	//	收到退出信号时取消正在执行的命令，各阶段保存进度后退出，再次收到信号时直接退出
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		received := <-signals
+		signal.Stop(signals)
+		slog.Warn("收到退出信号，等待正在执行的任务保存进度", "signal", received.String())
+		cancel()
+	}()
+
+	err = command.run(ctx, args)
+	if errors.Is(err, context.Canceled) {
+		slog.Warn("命令被中断", "command", command.Name)
+		storage.Close()
+		closeLog()
+		os.Exit(130)
 	}
 
-	//	更新所有股票的海龟指标
-	err = turtle.UpdateAll()
 	if err != nil {
-		log.Fatalf("更新海龟指标发生错误:%v", err)
-		return
+		slog.Error("执行命令发生错误", "command", command.Name, "error", err)
+		if !logging.Mirrored() {
+			fmt.Fprintf(os.Stderr, "执行%s发生错误:%v\n", command.Name, err)
+		}
+		storage.Close()
+		closeLog()
+		os.Exit(1)
 	}
+}
 
-	//	更新所有股票的区间极值指标
-	err = peroidexterma.UpdateAll()
-	if err != nil {
-		log.Fatalf("更新区间极值指标发生错误:%v", err)
-		return
+//	显示用法
+func usage(flags *flag.FlagSet) {
+
+	fmt.Fprintf(os.Stderr, "用法: tast [全局参数] <命令> [命令参数]\n\n全局参数:\n")
+	flags.PrintDefaults()
+
+	fmt.Fprintf(os.Stderr, "\n命令:\n")
+	for _, command := range commands {
+		fmt.Fprintf(os.Stderr, "  %-22s%s\n", command.Name, command.Summary)
 	}
 
-	//	测试海龟交易系统
-	err = trading.TestAll()
-	if err != nil {
-		log.Fatalf("测试海龟交易系统发生错误:%v", err)
-		return
+	fmt.Fprintf(os.Stderr, "\n使用 tast <命令> -h 查看命令参数\n")
+}
+
+//	命令行指定的配置，格式为 section.key=value
+type settings []string
+
+func (s *settings) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *settings) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+//	修改配置
+func (s settings) apply() error {
+	for _, setting := range s {
+		parts := strings.SplitN(setting, "=", 2)
+		keys := strings.SplitN(parts[0], ".", 2)
+		if len(parts) != 2 || len(keys) != 2 {
+			return fmt.Errorf("配置%s的格式不正确，应为 section.key=value", setting)
+		}
+
+		config.Set(strings.TrimSpace(keys[0]), strings.TrimSpace(keys[1]), strings.TrimSpace(parts[1]))
 	}
+
+	return nil
 }
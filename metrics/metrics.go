@@ -0,0 +1,202 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nzai/Tast/config"
+)
+
+const (
+	configSection    = "metrics"
+	configAddressKey = "address"
+	typeCounter      = "counter"
+	typeGauge        = "gauge"
+)
+
+//	监控指标，最多有一个标签，以Prometheus文本格式输出
+type Metric struct {
+	Name   string
+	Help   string
+	Type   string
+	Label  string //	标签名，为空时没有标签
+	mutex  sync.Mutex
+	values map[string]float64
+}
+
+var (
+	registry      = make([]*Metric, 0)
+	registryMutex sync.Mutex
+)
+
+//	注册指标
+func register(name, help, metricType, label string) *Metric {
+
+	metric := &Metric{
+		Name:   name,
+		Help:   help,
+		Type:   metricType,
+		Label:  label,
+		values: make(map[string]float64),
+	}
+
+	registryMutex.Lock()
+	registry = append(registry, metric)
+	registryMutex.Unlock()
+
+	return metric
+}
+
+//	只增不减的计数
+func NewCounter(name, help, label string) *Metric {
+	return register(name, help, typeCounter, label)
+}
+
+//	可以任意设置的数值
+func NewGauge(name, help, label string) *Metric {
+	return register(name, help, typeGauge, label)
+}
+
+//	增加数值，没有标签时label为空
+func (metric *Metric) Add(label string, delta float64) {
+	metric.mutex.Lock()
+	metric.values[label] += delta
+	metric.mutex.Unlock()
+}
+
+func (metric *Metric) Inc(label string) {
+	metric.Add(label, 1)
+}
+
+func (metric *Metric) Dec(label string) {
+	metric.Add(label, -1)
+}
+
+//	设置数值，计数不应使用
+func (metric *Metric) Set(label string, value float64) {
+	metric.mutex.Lock()
+	metric.values[label] = value
+	metric.mutex.Unlock()
+}
+
+//	输出指标
+func (metric *Metric) write(buffer *bytes.Buffer) {
+
+	metric.mutex.Lock()
+	defer metric.mutex.Unlock()
+
+	fmt.Fprintf(buffer, "# HELP %s %s\n", metric.Name, metric.Help)
+	fmt.Fprintf(buffer, "# TYPE %s %s\n", metric.Name, metric.Type)
+
+	labels := make([]string, 0, len(metric.values))
+	for label := range metric.values {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	//	没有标签的指标即使没有设置过也输出0
+	if metric.Label == "" && len(labels) == 0 {
+		labels = append(labels, "")
+	}
+
+	for _, label := range labels {
+		if metric.Label == "" {
+			fmt.Fprintf(buffer, "%s %s\n", metric.Name, formatValue(metric.values[label]))
+		} else {
+			fmt.Fprintf(buffer, "%s{%s=\"%s\"} %s\n", metric.Name, metric.Label, escape(label), formatValue(metric.values[label]))
+		}
+	}
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+//	转义标签值中的反斜杠、引号和换行
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+//	以Prometheus文本格式输出所有指标
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		registryMutex.Lock()
+		metrics := append([]*Metric(nil), registry...)
+		registryMutex.Unlock()
+
+		var buffer bytes.Buffer
+		for _, metric := range metrics {
+			metric.write(&buffer)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buffer.Bytes())
+	})
+}
+
+//	配置了监听地址时在后台提供/metrics
+func Start() {
+
+	address := config.GetString(configSection, configAddressKey, "")
+	if address == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+
+	go func() {
+		slog.Info("提供监控指标", "address", address)
+		err := http.ListenAndServe(address, mux)
+		if err != nil {
+			slog.Error("监控指标服务发生错误", "error", err)
+		}
+	}()
+}
+
+//	流水线的监控指标
+var (
+	StageCodes         = NewCounter("tast_stage_codes_processed_total", "各阶段处理完毕的股票数量", "stage")
+	StageFailures      = NewCounter("tast_stage_codes_failed_total", "各阶段处理失败的股票数量", "stage")
+	DownloadErrors     = NewCounter("tast_download_errors_total", "下载数据失败的次数", "source")
+	ParseFailures      = NewCounter("tast_parse_failures_total", "解析下载的数据失败的次数", "source")
+	FetchRetries       = NewCounter("tast_fetch_retries_total", "下载数据时重试的次数", "reason")
+	FetchCacheHits     = NewCounter("tast_fetch_cache_hits_total", "从磁盘缓存读取下载内容的次数", "")
+	Backtests          = NewCounter("tast_backtests_total", "完成的单只股票回测次数", "")
+	BacktestsPerSecond = NewGauge("tast_sweep_backtests_per_second", "参数遍历每秒完成的回测次数", "")
+	SweepCompletion    = NewGauge("tast_sweep_completion_ratio", "参数遍历的完成比例", "")
+	SweepBestProfit    = NewGauge("tast_sweep_best_profit", "参数遍历至今最优参数的收益", "")
+	WorkersBusy        = NewGauge("tast_workers_busy", "正在工作的goroutine数量", "pool")
+	WorkersCapacity    = NewGauge("tast_workers_capacity", "goroutine池的容量", "pool")
+	LastProgress       = NewGauge("tast_last_progress_timestamp_seconds", "最近一次处理完股票或回测的时间，长时间不变说明运行卡住", "")
+)
+
+//	一只股票在某个阶段处理完毕
+func CodeProcessed(stage string) {
+	StageCodes.Inc(stage)
+	LastProgress.Set("", float64(time.Now().Unix()))
+}
+
+//	一次回测完成
+func BacktestFinished() {
+	Backtests.Inc("")
+	LastProgress.Set("", float64(time.Now().Unix()))
+}
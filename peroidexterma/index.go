@@ -1,20 +1,17 @@
 package peroidexterma
 
 import (
-	"bufio"
+	"context"
 	"errors"
 	"fmt"
-	"log"
 	"math"
-	"os"
-	"path/filepath"
 
-	"strconv"
-	"strings"
-
-	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/failure"
 	"github.com/nzai/Tast/history"
+	"github.com/nzai/Tast/logging"
 	"github.com/nzai/Tast/stock"
+	"github.com/nzai/Tast/storage"
+	"github.com/nzai/Tast/workpool"
 )
 
 type PeroidExtermaIndex struct {
@@ -23,87 +20,143 @@ type PeroidExtermaIndex struct {
 	Date   string
 	Min    float64 //	最小值
 	Max    float64 //	最大值
+	Valid  bool    //	窗口是否已满，未满时Min和Max为NaN
 }
 
 const (
-	peroidMin    = 2
-	peroidMax    = 50
-	dataFileName = "PeroidExterma.txt"
+	peroidMin = 2
+	peroidMax = 50
 )
 
-//	更新区间极值指数
-func UpdateAll() error {
+//	指标计算方法的版本，改变后已保存的指标需要重新计算，版本2开始窗口未满时极值为NaN
+const indexVersion = 2
 
-	log.Println("开始更新区间极值指标")
+//	更新所有股票的区间极值指数
+func UpdateAll(ctx context.Context) error {
 
-	//	数据保存目录
-	dataDir, err := config.GetDataDir()
+	//	获取所有股票
+	codes, err := stock.GetCodes()
 	if err != nil {
 		return err
 	}
 
-	//	获取所有股票
-	stocks, err := stock.GetAll()
+	return Update(ctx, codes)
+}
+
+//	更新指定股票的区间极值指数
+func Update(ctx context.Context, codes []string) error {
+
+	logger := logging.Stage("peroidexterma")
+	logger.Info("开始更新区间极值指标", "codes", len(codes))
+
+	//	数据存储
+	store, err := storage.Default()
+	if err != nil {
+		return err
+	}
+
+	//	K线周期
+	timeframe, err := history.DefaultTimeframe()
 	if err != nil {
 		return err
 	}
 
 	//log.Printf("共有股票%d只", len(stocks))
 
-	for _, stock := range stocks {
-		//	更新每只股票的指标
-		err = updateStock(stock.Code, dataDir)
+	failures := failure.New("peroidexterma")
+	err = workpool.Calculate(ctx, workpool.Shared(), codes, prepare(timeframe, store), failures.Record, failures.Stopped)
+	if err != nil {
+		logger.Warn("区间极值指标更新被取消")
+		return err
+	}
+
+	logger.Info("区间极值指标更新完毕")
+
+	return failures.Err()
+}
+
+//	一只股票尚未用当前版本的方法计算的区间极值指标，按周期拆分计算
+func prepare(timeframe history.Timeframe, store storage.Store) workpool.Prepare {
+	return func(code string) ([]workpool.Kind, error) {
+
+		kind := timeframe.Kind(storage.KindPeroidExterma)
+		found, err := storage.Current(store, code, kind, indexVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		if found {
+			//	如果已经用当前版本的方法保存过就跳过不重新计算
+			return nil, nil
+		}
+
+		//	获取股票在该周期下的K线
+		histories, err := history.GetStockBars(code, timeframe)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
+
+		return []workpool.Kind{{
+			Peroids: peroids(),
+			Calculate: func(peroid int) (interface{}, error) {
+				return calculate(histories, peroid)
+			},
+			Save: func(results map[int]interface{}) error {
+				allIndex := make(map[int][]PeroidExtermaIndex, len(results))
+				for peroid, result := range results {
+					allIndex[peroid] = result.([]PeroidExtermaIndex)
+				}
+
+				return save(code, kind, allIndex, store)
+			},
+		}}, nil
 	}
+}
 
-	log.Println("区间极值指标更新完毕")
+//	计算的周期
+func peroids() []int {
+	list := make([]int, 0, peroidMax-peroidMin+1)
+	for peroid := peroidMin; peroid <= peroidMax; peroid++ {
+		list = append(list, peroid)
+	}
 
-	return err
+	return list
 }
 
-func updateStock(code string, dataDir string) error {
-	//	获取股票每日历史
-	histories, err := history.GetStockDailyHistory(code, dataDir)
-	if err != nil {
-		return err
+//	检查周期是否在计算的范围内
+func CheckPeroid(peroid int) error {
+
+	if peroid < peroidMin || peroid > peroidMax {
+		return fmt.Errorf("区间极值指标不支持周期%d，支持的周期为%d到%d", peroid, peroidMin, peroidMax)
 	}
 
-	filePath := filepath.Join(dataDir, code, dataFileName)
-	_, err = os.Stat(filePath)
-	if !os.IsNotExist(err) {
-		//	如果文件存在就跳过不重新计算
-		return nil
+	return nil
+}
+
+//	获取股票的区间极值指标
+func GetStockIndex(code string, timeframe history.Timeframe) (map[int][]PeroidExtermaIndex, error) {
+
+	//	数据存储
+	store, err := storage.Default()
+	if err != nil {
+		return nil, err
 	}
-	//log.Printf("股票%s历史记录有%d天", code, len(histories))
 
-	allIndex := make(map[int][]PeroidExtermaIndex)
-	chanReceive := make(chan int)
-
-	//	并发计算指标
-	go func() {
-		for peroid := peroidMin; peroid <= peroidMax; peroid++ {
-			go func(p int) {
-				//	更新股票在周期为peroid时的指数
-				indexes, err := calculate(histories, p)
-				if err != nil {
-					log.Fatal(err)
-				}
+	kind := timeframe.Kind(storage.KindPeroidExterma)
+	found, err := storage.Current(store, code, kind, indexVersion)
+	if err != nil {
+		return nil, err
+	}
 
-				allIndex[p] = indexes
-				chanReceive <- 1
-			}(peroid)
+	if !found {
+		//	如果没有保存过或者由旧版本的方法计算就先计算指标
+		err = workpool.CalculateStock(context.Background(), workpool.Shared(), code, prepare(timeframe, store))
+		if err != nil {
+			return nil, err
 		}
-	}()
-
-	//	阻塞，直到所有股票更新完历史
-	for peroid := peroidMin; peroid <= peroidMax; peroid++ {
-		<-chanReceive
 	}
 
-	//	保存
-	return save(code, allIndex, filePath)
+	return load(code, kind, store)
 }
 
 //	获取股票历史的最大最小值
@@ -136,10 +189,12 @@ func calculate(histories []history.DailyHistory, peroid int) ([]PeroidExtermaInd
 			queue = append(queue, history)
 		}
 
-		if index == 0 {
-			min, max = history.Low, history.High
-		} else {
+		//	窗口未满时指标无效
+		valid := index >= peroid-1
+		if valid {
 			min, max = peroidExterma(queue)
+		} else {
+			min, max = math.NaN(), math.NaN()
 		}
 
 		list = append(list, PeroidExtermaIndex{
@@ -148,21 +203,17 @@ func calculate(histories []history.DailyHistory, peroid int) ([]PeroidExtermaInd
 			Date:   history.Date,
 			Min:    min,
 			Max:    max,
+			Valid:  valid,
 		})
 	}
 
 	return list, nil
 }
 
-//	将指标保存到文件
-func save(code string, allIndex map[int][]PeroidExtermaIndex, filePath string) error {
-	//	打开文件
-	file, err := os.OpenFile(filePath, os.O_CREATE, 0x777)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+//	保存指标
+func save(code, kind string, allIndex map[int][]PeroidExtermaIndex, store storage.Store) error {
 
+	records := make([]storage.Record, 0)
 	for peroid := peroidMin; peroid <= peroidMax; peroid++ {
 
 		indexes, found := allIndex[peroid]
@@ -171,68 +222,43 @@ func save(code string, allIndex map[int][]PeroidExtermaIndex, filePath string) e
 		}
 
 		for _, index := range indexes {
-			line := fmt.Sprintf("%d\t%s\t%.6f\t%.6f\n",
-				index.Peroid,
-				index.Date,
-				index.Max,
-				index.Min)
-
-			//	将股价写入文件
-			_, err = file.WriteString(line)
-			if err != nil {
-				return err
-			}
+			records = append(records, storage.Record{
+				Peroid: index.Peroid,
+				Date:   index.Date,
+				Values: []float64{index.Max, index.Min},
+			})
 		}
 	}
 
-	return nil
+	return storage.SaveVersion(store, code, kind, records, indexVersion)
 }
 
-//	从文件中读入指标
-func load(code, filePath string) (map[int][]PeroidExtermaIndex, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
+//	从存储中读入指标
+func load(code, kind string, store storage.Store) (map[int][]PeroidExtermaIndex, error) {
 
-	scanner := bufio.NewScanner(file)
 	allIndex := make(map[int][]PeroidExtermaIndex)
-	for scanner.Scan() {
-		parts := strings.Split(scanner.Text(), "\t")
-		if len(parts) != 4 {
-			return nil, errors.New("股票列表文件格式不正确")
+	err := storage.LoadColumns(store, code, kind, func(peroid int, dates []string, columns [][]float64) error {
+		if len(columns) != 2 {
+			return errors.New("区间极值指标格式不正确")
 		}
 
-		peroid64, err := strconv.ParseInt(parts[0], 10, 64)
-		if err != nil {
-			return nil, err
-		}
-		peroid := int(peroid64)
-
-		max, err := strconv.ParseFloat(parts[2], 64)
-		if err != nil {
-			return nil, err
-		}
-
-		min, err := strconv.ParseFloat(parts[3], 64)
-		if err != nil {
-			return nil, err
-		}
-
-		indexes, found := allIndex[peroid]
-		if !found {
-			allIndex[peroid] = make([]PeroidExtermaIndex, 0)
-			indexes = allIndex[peroid]
+		indexes := make([]PeroidExtermaIndex, len(dates))
+		for index, date := range dates {
+			indexes[index] = PeroidExtermaIndex{
+				Code:   code,
+				Peroid: peroid,
+				Date:   date,
+				Max:    columns[0][index],
+				Min:    columns[1][index],
+				Valid:  !math.IsNaN(columns[0][index]) && !math.IsNaN(columns[1][index]),
+			}
 		}
+		allIndex[peroid] = indexes
 
-		indexes = append(indexes, PeroidExtermaIndex{
-			Code:   code,
-			Peroid: peroid,
-			Date:   parts[1],
-			Min:    min,
-			Max:    max,
-		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return allIndex, nil
@@ -0,0 +1,415 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nzai/Tast/atomicfile"
+	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/failure"
+	"github.com/nzai/Tast/logging"
+	"github.com/nzai/Tast/stock"
+)
+
+const (
+	fingerprintFileName = "Pipeline.txt"
+	allCodes            = "*" //	不区分股票的阶段在指纹文件中的代码
+)
+
+//	只影响计算方式而不影响结果的配置项，不计入指纹
+var ignoredKeys = map[string]bool{
+	"indicator.workers": true,
+}
+
+//	流水线中的一个阶段
+//	每个阶段对每只股票(或整体)计算输入指纹，指纹由阶段名称、算法版本、相关配置、输入数据以及依赖阶段的指纹组成，
+//	与上次运行记录的指纹不一致时才重新计算，因此上游的变化会沿依赖传递到下游
+type Stage struct {
+	Name     string
+	Version  int      //	算法版本，修改计算方法后递增
+	Depends  []string //	依赖的阶段
+	PerCode  bool     //	是否按股票分别计算
+	Always   bool     //	数据来源，每次都运行，由其自身判断是否需要更新
+	Sections []string //	影响结果的配置节
+	//	除配置外的输入数据，可以为空
+	Inputs func(code string) ([]byte, error)
+	//	删除已计算的结果，使Run重新计算，可以为空
+	Invalidate func(code string) error
+	//	计算指定股票，不区分股票的阶段codes为nil，ctx被取消时应尽快返回
+	Run func(ctx context.Context, codes []string) error
+}
+
+//	需要重新计算的股票及原因
+type staleCode struct {
+	Code   string
+	Reason string
+}
+
+//	流水线
+type Pipeline struct {
+	Stages []Stage
+	Output io.Writer //	试运行时输出需要重新计算的内容
+}
+
+//	默认的流水线
+func Default() *Pipeline {
+	return &Pipeline{Stages: stages, Output: os.Stdout}
+}
+
+//	依次运行各阶段，只重新计算输入有变化的部分
+//	codes为空时使用所有股票，dryRun为true时只输出需要重新计算的内容
+//	ctx被取消时尽快返回，已完成的阶段的指纹已经保存，下次运行时不需要重新计算
+func (pipeline *Pipeline) Run(ctx context.Context, codes []string, dryRun bool) error {
+
+	dataDir, err := config.GetDataDir()
+	if err != nil {
+		return err
+	}
+
+	filePath := filepath.Join(dataDir, fingerprintFileName)
+	recorded, err := loadFingerprints(filePath)
+	if err != nil {
+		return err
+	}
+
+	//	本次运行各阶段的指纹
+	current := make(map[string]map[string]string)
+	//	处理失败的股票，后续按股票计算的阶段跳过这些股票
+	failures := make([]*failure.Errors, 0)
+	failed := make(map[string]bool)
+	for _, stage := range pipeline.Stages {
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		for _, depend := range stage.Depends {
+			if _, found := current[depend]; !found && !pipeline.isSource(depend) {
+				return fmt.Errorf("阶段%s依赖的阶段%s不存在或不在其之前", stage.Name, depend)
+			}
+		}
+
+		//	数据来源每次都运行
+		if stage.Always {
+			if dryRun {
+				fmt.Fprintf(pipeline.Output, "%s: 每次运行\n", stage.Name)
+			} else {
+				err = pipeline.runSource(ctx, stage, &codes)
+				err = collect(err, &failures, failed)
+				if err != nil {
+					return err
+				}
+			}
+
+			continue
+		}
+
+		//	股票列表在数据来源更新后确定
+		if codes == nil {
+			codes, err = stock.GetCodes()
+			if err != nil {
+				return err
+			}
+		}
+
+		targets := []string{allCodes}
+		if stage.PerCode {
+			targets = make([]string, 0, len(codes))
+			for _, code := range codes {
+				if !failed[code] {
+					targets = append(targets, code)
+				}
+			}
+		}
+
+		current[stage.Name] = make(map[string]string)
+		stale := make([]staleCode, 0)
+		for _, code := range targets {
+			fingerprint, err := stage.fingerprint(code, codes, current)
+			if err != nil {
+				return err
+			}
+
+			current[stage.Name][code] = fingerprint
+			previous, found := recorded[stage.Name][code]
+			switch {
+			case !found:
+				stale = append(stale, staleCode{code, "没有计算记录"})
+			case previous != fingerprint:
+				stale = append(stale, staleCode{code, "输入有变化"})
+			}
+		}
+
+		if dryRun {
+			pipeline.print(stage, len(targets), stale)
+			continue
+		}
+
+		if len(stale) == 0 {
+			logging.Stage(stage.Name).Info("没有需要重新计算的内容")
+			continue
+		}
+
+		logging.Stage(stage.Name).Info("需要重新计算", "stale", len(stale), "total", len(targets))
+		err = collect(stage.run(ctx, stale), &failures, failed)
+		if err != nil {
+			return fmt.Errorf("阶段%s发生错误:%w", stage.Name, err)
+		}
+
+		//	计算可能修改输入(例如隔离历史记录)，记录计算后的指纹
+		if recorded[stage.Name] == nil {
+			recorded[stage.Name] = make(map[string]string)
+		}
+
+		for _, item := range stale {
+			//	失败的股票不记录指纹，下次运行时重新计算
+			if failed[item.Code] {
+				delete(current[stage.Name], item.Code)
+				continue
+			}
+
+			fingerprint, err := stage.fingerprint(item.Code, codes, current)
+			if err != nil {
+				return err
+			}
+
+			current[stage.Name][item.Code] = fingerprint
+			recorded[stage.Name][item.Code] = fingerprint
+		}
+
+		//	每个阶段结束后保存，中断后已完成的阶段不需要重新计算
+		err = saveFingerprints(filePath, recorded)
+		if err != nil {
+			return err
+		}
+	}
+
+	return pipeline.summary(failures)
+}
+
+//	收集部分股票失败的错误，严格模式下或其他错误直接返回
+func collect(err error, failures *[]*failure.Errors, failed map[string]bool) error {
+
+	var errs *failure.Errors
+	if err == nil || !errors.As(err, &errs) || failure.Strict() {
+		return err
+	}
+
+	*failures = append(*failures, errs)
+	for _, code := range errs.Codes() {
+		failed[code] = true
+	}
+
+	return nil
+}
+
+//	输出失败股票的汇总，有失败时返回错误
+func (pipeline *Pipeline) summary(failures []*failure.Errors) error {
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	codes := 0
+	for _, errs := range failures {
+		fmt.Fprintln(pipeline.Output, errs.Error())
+		codes += len(errs.Failures)
+	}
+
+	logging.Stage("pipeline").Error("流水线运行结束，部分股票处理失败", "stages", len(failures), "failures", codes)
+
+	return fmt.Errorf("流水线中有%d个阶段共%d只股票处理失败", len(failures), codes)
+}
+
+//	阶段是否为数据来源
+func (pipeline *Pipeline) isSource(name string) bool {
+	for _, stage := range pipeline.Stages {
+		if stage.Name == name {
+			return stage.Always
+		}
+	}
+
+	return false
+}
+
+//	运行数据来源
+func (pipeline *Pipeline) runSource(ctx context.Context, stage Stage, codes *[]string) error {
+
+	if !stage.PerCode {
+		return stage.Run(ctx, nil)
+	}
+
+	if *codes == nil {
+		list, err := stock.GetCodes()
+		if err != nil {
+			return err
+		}
+		*codes = list
+	}
+
+	return stage.Run(ctx, *codes)
+}
+
+//	输出试运行的结果
+func (pipeline *Pipeline) print(stage Stage, total int, stale []staleCode) {
+
+	fmt.Fprintf(pipeline.Output, "%s: 需要重新计算%d/%d\n", stage.Name, len(stale), total)
+	for _, item := range stale {
+		if item.Code == allCodes {
+			fmt.Fprintf(pipeline.Output, "\t%s\n", item.Reason)
+		} else {
+			fmt.Fprintf(pipeline.Output, "\t%s\t%s\n", item.Code, item.Reason)
+		}
+	}
+}
+
+//	删除已计算的结果并重新计算
+func (stage Stage) run(ctx context.Context, stale []staleCode) error {
+
+	if !stage.PerCode {
+		return stage.Run(ctx, nil)
+	}
+
+	codes := make([]string, 0, len(stale))
+	for _, item := range stale {
+		if stage.Invalidate != nil {
+			err := stage.Invalidate(item.Code)
+			if err != nil {
+				return err
+			}
+		}
+
+		codes = append(codes, item.Code)
+	}
+
+	return stage.Run(ctx, codes)
+}
+
+//	计算阶段的输入指纹，不区分股票的阶段依赖所有股票的上游指纹
+func (stage Stage) fingerprint(code string, codes []string, current map[string]map[string]string) (string, error) {
+
+	hash := sha1.New()
+	fmt.Fprintf(hash, "%s\t%d\n", stage.Name, stage.Version)
+
+	for _, section := range stage.Sections {
+		values := config.GetSection(section)
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			if !ignoredKeys[section+"."+key] {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fmt.Fprintf(hash, "%s.%s=%s\n", section, key, values[key])
+		}
+	}
+
+	if stage.Inputs != nil {
+		buffer, err := stage.Inputs(code)
+		if err != nil {
+			return "", err
+		}
+		hash.Write(buffer)
+	}
+
+	for _, depend := range stage.Depends {
+		fingerprints := current[depend]
+		if fingerprints == nil {
+			//	数据来源没有指纹
+			continue
+		}
+
+		if fingerprint, found := fingerprints[allCodes]; found {
+			fmt.Fprintf(hash, "%s\t%s\n", depend, fingerprint)
+			continue
+		}
+
+		if code != allCodes {
+			fmt.Fprintf(hash, "%s\t%s\n", depend, fingerprints[code])
+			continue
+		}
+
+		for _, c := range codes {
+			fmt.Fprintf(hash, "%s\t%s\t%s\n", depend, c, fingerprints[c])
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+//	读取上次运行记录的指纹，每行依次为阶段、股票代码、指纹
+func loadFingerprints(filePath string) (map[string]map[string]string, error) {
+
+	fingerprints := make(map[string]map[string]string)
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return fingerprints, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.Split(scanner.Text(), "\t")
+		if len(parts) != 3 {
+			continue
+		}
+
+		if fingerprints[parts[0]] == nil {
+			fingerprints[parts[0]] = make(map[string]string)
+		}
+		fingerprints[parts[0]][parts[1]] = parts[2]
+	}
+
+	return fingerprints, scanner.Err()
+}
+
+//	保存指纹
+func saveFingerprints(filePath string, fingerprints map[string]map[string]string) error {
+
+	file, err := atomicfile.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stages := make([]string, 0, len(fingerprints))
+	for stage := range fingerprints {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+
+	writer := bufio.NewWriter(file)
+	for _, stage := range stages {
+		codes := make([]string, 0, len(fingerprints[stage]))
+		for code := range fingerprints[stage] {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+
+		for _, code := range codes {
+			fmt.Fprintf(writer, "%s\t%s\t%s\n", stage, code, fingerprints[stage][code])
+		}
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	return file.Commit()
+}
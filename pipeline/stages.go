@@ -0,0 +1,226 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/history"
+	"github.com/nzai/Tast/history/validate"
+	"github.com/nzai/Tast/indicator"
+	"github.com/nzai/Tast/peroidexterma"
+	"github.com/nzai/Tast/stock"
+	"github.com/nzai/Tast/storage"
+	"github.com/nzai/Tast/trading"
+	"github.com/nzai/Tast/turtle"
+)
+
+const (
+	configBenchmarkSection = "benchmark"
+	configBenchmarkIndex   = "index"
+	configStrategySection  = "strategy"
+	configStrategiesKey    = "strategies"
+	ruleSpecPrefix         = "rule:"
+)
+
+//	默认流水线的各阶段，按依赖顺序排列
+var stages = []Stage{
+	{
+		Name:   "stocks",
+		Always: true,
+		Run: func(ctx context.Context, codes []string) error {
+			return stock.UpdateAll(ctx)
+		},
+	},
+	{
+		Name:    "history",
+		PerCode: true,
+		Always:  true,
+		Depends: []string{"stocks"},
+		Run:     history.Update,
+	},
+	{
+		Name:     "validate",
+		Version:  1,
+		PerCode:  true,
+		Depends:  []string{"history"},
+		Sections: []string{"validate"},
+		Inputs:   dailyInputs,
+		Run:      validate.Update,
+	},
+	{
+		Name:       "turtle",
+		Version:    1,
+		PerCode:    true,
+		Depends:    []string{"validate"},
+		Sections:   []string{"indicator", "turtle"},
+		Inputs:     dailyInputs,
+		Invalidate: turtleInvalidate,
+		Run:        turtle.Update,
+	},
+	{
+		Name:       "peroidexterma",
+		Version:    1,
+		PerCode:    true,
+		Depends:    []string{"validate"},
+		Sections:   []string{"indicator"},
+		Inputs:     dailyInputs,
+		Invalidate: peroidExtermaInvalidate,
+		Run:        peroidexterma.Update,
+	},
+	{
+		Name:       "indicator",
+		Version:    1,
+		PerCode:    true,
+		Depends:    []string{"validate"},
+		Sections:   []string{"indicator"},
+		Inputs:     dailyInputs,
+		Invalidate: indicatorInvalidate,
+		Run:        indicator.Update,
+	},
+	{
+		Name:     "strategies",
+		Version:  1,
+		Depends:  []string{"turtle", "peroidexterma", "indicator"},
+		Sections: []string{configBenchmarkSection, "trading", "filter", configStrategySection},
+		Inputs:   strategyInputs,
+		Run: func(ctx context.Context, codes []string) error {
+			return trading.TestStrategies(ctx)
+		},
+	},
+	{
+		Name:     "sweep",
+		Version:  1,
+		Depends:  []string{"turtle", "peroidexterma"},
+		Sections: []string{configBenchmarkSection, "trading", "filter"},
+		Inputs:   benchmarkInputs,
+		Run: func(ctx context.Context, codes []string) error {
+			return trading.TestAll(ctx)
+		},
+	},
+}
+
+//	股票每日历史的内容，与存储引擎无关
+func dailyInputs(code string) ([]byte, error) {
+
+	store, err := storage.Default()
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := store.Exists(code, storage.KindDaily)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	records, err := store.Load(code, storage.KindDaily)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	for _, record := range records {
+		fmt.Fprintf(&buffer, "%s\t%v\n", record.Date, record.Values)
+	}
+
+	return buffer.Bytes(), nil
+}
+
+//	基准指数的每日历史
+func benchmarkInputs(code string) ([]byte, error) {
+
+	index := config.GetString(configBenchmarkSection, configBenchmarkIndex, "")
+	if index == "" {
+		return nil, nil
+	}
+
+	return dailyInputs(index)
+}
+
+//	基准指数以及策略引用的规则文件
+func strategyInputs(code string) ([]byte, error) {
+
+	buffer, err := benchmarkInputs(code)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, spec := range strings.Split(config.GetString(configStrategySection, configStrategiesKey, ""), ",") {
+		spec = strings.TrimSpace(spec)
+		if !strings.HasPrefix(spec, ruleSpecPrefix) {
+			continue
+		}
+
+		rule, err := ioutil.ReadFile(strings.TrimPrefix(spec, ruleSpecPrefix))
+		if err != nil {
+			return nil, err
+		}
+
+		buffer = append(buffer, rule...)
+	}
+
+	return buffer, nil
+}
+
+//	删除当前K线周期下的海龟指标
+func turtleInvalidate(code string) error {
+
+	estimators, err := turtle.DefaultEstimators()
+	if err != nil {
+		return err
+	}
+
+	kinds := make([]string, 0, len(estimators))
+	for _, estimator := range estimators {
+		kinds = append(kinds, estimator.Kind())
+	}
+
+	return remove(code, kinds)
+}
+
+//	删除当前K线周期下的区间极值指标
+func peroidExtermaInvalidate(code string) error {
+	return remove(code, []string{storage.KindPeroidExterma})
+}
+
+//	删除当前K线周期下的技术指标
+func indicatorInvalidate(code string) error {
+
+	indicators, err := indicator.DefaultIndicators()
+	if err != nil {
+		return err
+	}
+
+	kinds := make([]string, 0, len(indicators))
+	for _, ind := range indicators {
+		kinds = append(kinds, ind.Name())
+	}
+
+	return remove(code, kinds)
+}
+
+//	删除股票在当前K线周期下的数据
+func remove(code string, kinds []string) error {
+
+	store, err := storage.Default()
+	if err != nil {
+		return err
+	}
+
+	timeframe, err := history.DefaultTimeframe()
+	if err != nil {
+		return err
+	}
+
+	for _, kind := range kinds {
+		err = store.Remove(code, timeframe.Kind(kind))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//	网页界面的静态文件
+//
+//go:embed web
+var webFiles embed.FS
+
+//	网页界面，显示参数遍历的进度、收益最高的参数组合以及股票的K线图
+func dashboard() http.Handler {
+
+	files, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+
+	return http.FileServer(http.FS(files))
+}
@@ -0,0 +1,378 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/logging"
+	"github.com/nzai/Tast/metrics"
+	"github.com/nzai/Tast/trading"
+)
+
+//	任务状态
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+//	任务种类
+const (
+	KindBacktest = "backtest"
+	KindSweep    = "sweep"
+)
+
+//	遍历时可以指定范围的入市过滤条件
+var filterKeys = []string{"trend", "adx", "indextrend", "volatilitymin", "volatilitymax"}
+
+//	启动回测或参数遍历的请求，未指定的项使用配置文件中的值
+type JobRequest struct {
+	Strategy   string                                `json:"strategy,omitempty"` //	策略描述，与params二选一
+	Params     *trading.TurtleTradingSystemParameter `json:"params,omitempty"`   //	海龟交易系统参数
+	Codes      []string                              `json:"codes,omitempty"`
+	Timeframe  string                                `json:"timeframe,omitempty"`
+	Volatility string                                `json:"volatility,omitempty"`
+	Fill       string                                `json:"fill,omitempty"`
+	Filters    map[string]string                     `json:"filters,omitempty"` //	入市过滤条件，可以是 开始-结束:步长 的范围
+}
+
+//	请求对应的配置
+func (request JobRequest) settings() ([][3]string, error) {
+
+	settings := make([][3]string, 0)
+	if len(request.Codes) > 0 {
+		codes := make([]string, 0, len(request.Codes))
+		for _, code := range request.Codes {
+			code = strings.ToUpper(strings.TrimSpace(code))
+			err := checkCode(code)
+			if err != nil {
+				return nil, err
+			}
+
+			codes = append(codes, code)
+		}
+
+		settings = append(settings, [3]string{"trading", "codes", strings.Join(codes, ",")})
+	}
+
+	for _, item := range [][3]string{
+		{"indicator", "timeframe", request.Timeframe},
+		{"trading", "volatility", request.Volatility},
+		{"trading", "fill", request.Fill},
+	} {
+		if item[2] != "" {
+			settings = append(settings, item)
+		}
+	}
+
+	for key, value := range request.Filters {
+		found := false
+		for _, filterKey := range filterKeys {
+			found = found || filterKey == strings.ToLower(key)
+		}
+
+		if !found {
+			return nil, fmt.Errorf("不支持的入市过滤条件:%s", key)
+		}
+
+		settings = append(settings, [3]string{"filter", strings.ToLower(key), value})
+	}
+
+	return settings, nil
+}
+
+//	后台任务
+type Job struct {
+	ID       string      `json:"id"`
+	Kind     string      `json:"kind"`
+	Request  JobRequest  `json:"request"`
+	Status   string      `json:"status"`
+	Error    string      `json:"error,omitempty"`
+	Created  time.Time   `json:"created"`
+	Started  *time.Time  `json:"started,omitempty"`
+	Finished *time.Time  `json:"finished,omitempty"`
+	Progress *Progress   `json:"progress,omitempty"`
+	result   interface{} //	任务结果，完成后才有
+}
+
+//	参数遍历的进度，与TradingSystem.txt的内容对应
+type Progress struct {
+	CalculatingAmount int64                                `json:"calculatingAmount"`
+	CalculatedAmount  int64                                `json:"calculatedAmount"`
+	CalculatedSeconds int64                                `json:"calculatedSeconds"`
+	RemainTips        string                               `json:"remainTips"`
+	Start             trading.TurtleTradingSystemParameter `json:"start"`
+	End               trading.TurtleTradingSystemParameter `json:"end"`
+	Current           trading.TurtleTradingSystemParameter `json:"current"`
+	CurrentProfit     float64                              `json:"currentProfit"`
+	Best              trading.TurtleTradingSystemParameter `json:"best"`
+	BestProfit        float64                              `json:"bestProfit"`
+	BestProfitPercent float64                              `json:"bestProfitPercent"`
+	BestBenchmarks    []trading.BenchmarkMetrics           `json:"bestBenchmarks"`
+	Top               []trading.ParameterResult            `json:"top"`
+}
+
+//	当前参数遍历的进度
+func currentProgress() *Progress {
+
+	system, found := trading.Progress()
+	if !found {
+		return nil
+	}
+
+	return &Progress{
+		CalculatingAmount: system.CalculatingAmount,
+		CalculatedAmount:  system.CalculatedAmount,
+		CalculatedSeconds: system.CalculatedSeconds,
+		RemainTips:        system.RemainTips,
+		Start:             system.Start,
+		End:               system.End,
+		Current:           system.Current,
+		CurrentProfit:     system.CurrentProfit,
+		Best:              system.Best,
+		BestProfit:        system.BestProfit,
+		BestProfitPercent: system.BestProfitPercent,
+		BestBenchmarks:    system.BestBenchmarks,
+		Top:               system.Top,
+	}
+}
+
+//	任务管理，配置和交易系统都是全局的，任务按提交顺序逐个执行
+//	ctx被取消时正在执行的任务尽快结束，尚未开始的任务不再执行
+type jobManager struct {
+	ctx   context.Context
+	mutex sync.Mutex
+	jobs  map[string]*Job
+	order []string
+	queue chan *Job
+	count int
+	done  chan struct{} //	执行任务的goroutine退出后关闭
+	lock  chan struct{} //	使用全局配置和交易系统的权利，任务和回测请求依次取得
+}
+
+func newJobManager(ctx context.Context) *jobManager {
+
+	manager := &jobManager{
+		ctx:   ctx,
+		jobs:  make(map[string]*Job),
+		order: make([]string, 0),
+		queue: make(chan *Job, 64),
+		done:  make(chan struct{}),
+		lock:  make(chan struct{}, 1),
+	}
+
+	metrics.WorkersCapacity.Set("jobs", 1)
+	go manager.work()
+
+	return manager
+}
+
+//	提交任务
+func (manager *jobManager) submit(kind string, request JobRequest) (Job, error) {
+
+	if kind == KindBacktest && (request.Strategy == "") == (request.Params == nil) {
+		return Job{}, errors.New("必须指定strategy或params中的一个")
+	}
+
+	err := checkStrategy(request.Strategy)
+	if err != nil {
+		return Job{}, err
+	}
+
+	_, err = request.settings()
+	if err != nil {
+		return Job{}, err
+	}
+
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	manager.count++
+	job := &Job{
+		ID:      strconv.Itoa(manager.count),
+		Kind:    kind,
+		Request: request,
+		Status:  JobQueued,
+		Created: time.Now(),
+	}
+
+	select {
+	case manager.queue <- job:
+	default:
+		manager.count--
+		return Job{}, errors.New("等待执行的任务太多")
+	}
+
+	manager.jobs[job.ID] = job
+	manager.order = append(manager.order, job.ID)
+
+	return *job, nil
+}
+
+//	任务的副本，正在执行的参数遍历附带进度
+func (manager *jobManager) get(id string) (Job, interface{}, bool) {
+
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	job, found := manager.jobs[id]
+	if !found {
+		return Job{}, nil, false
+	}
+
+	snapshot := *job
+	if job.Kind == KindSweep && job.Status == JobRunning {
+		snapshot.Progress = currentProgress()
+	}
+
+	return snapshot, job.result, true
+}
+
+//	所有任务，按提交顺序排列
+func (manager *jobManager) list() []Job {
+
+	ids := make([]string, 0)
+	manager.mutex.Lock()
+	ids = append(ids, manager.order...)
+	manager.mutex.Unlock()
+
+	jobs := make([]Job, 0, len(ids))
+	for _, id := range ids {
+		job, _, _ := manager.get(id)
+		jobs = append(jobs, job)
+	}
+
+	return jobs
+}
+
+//	取得使用全局配置和交易系统的权利后执行fn，ctx被取消时放弃等待
+func (manager *jobManager) exclusive(ctx context.Context, fn func() error) error {
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case manager.lock <- struct{}{}:
+	}
+	defer func() { <-manager.lock }()
+
+	return fn()
+}
+
+//	正在执行的参数遍历的进度，没有时为最近完成的参数遍历的进度
+func (manager *jobManager) progress() *Progress {
+
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	for index := len(manager.order) - 1; index >= 0; index-- {
+		job := manager.jobs[manager.order[index]]
+		if job.Kind != KindSweep || job.Status == JobQueued {
+			continue
+		}
+
+		if job.Status == JobRunning {
+			return currentProgress()
+		}
+
+		if job.Progress != nil {
+			return job.Progress
+		}
+	}
+
+	return nil
+}
+
+//	逐个执行任务
+func (manager *jobManager) work() {
+
+	defer close(manager.done)
+
+	for {
+		var job *Job
+		select {
+		case <-manager.ctx.Done():
+			return
+		case job = <-manager.queue:
+		}
+
+		manager.mutex.Lock()
+		started := time.Now()
+		job.Status, job.Started = JobRunning, &started
+		manager.mutex.Unlock()
+
+		logger := logging.Stage("server").With("job", job.ID, "kind", job.Kind)
+		logger.Info("开始执行任务")
+		metrics.WorkersBusy.Inc("jobs")
+		var result interface{}
+		err := manager.exclusive(manager.ctx, func() error {
+			var err error
+			result, err = execute(manager.ctx, job.Kind, job.Request)
+			return err
+		})
+		metrics.WorkersBusy.Dec("jobs")
+
+		manager.mutex.Lock()
+		finished := time.Now()
+		job.Finished = &finished
+		if err != nil {
+			job.Status, job.Error = JobFailed, err.Error()
+			logger.Error("任务发生错误", "error", err)
+		} else {
+			job.Status, job.result = JobDone, result
+			if job.Kind == KindSweep {
+				job.Progress, _ = result.(*Progress)
+			}
+			logger.Info("任务执行完毕")
+		}
+		manager.mutex.Unlock()
+	}
+}
+
+//	按请求修改配置并执行任务，结束后恢复配置
+func execute(ctx context.Context, kind string, request JobRequest) (interface{}, error) {
+
+	settings, err := request.settings()
+	if err != nil {
+		return nil, err
+	}
+
+	for index, setting := range settings {
+		previous := config.GetString(setting[0], setting[1], "")
+		config.Set(setting[0], setting[1], setting[2])
+		settings[index][2] = previous
+	}
+
+	//	恢复配置后丢弃按任务的配置创建的交易系统，以免影响之后的请求
+	defer func() {
+		for _, setting := range settings {
+			config.Set(setting[0], setting[1], setting[2])
+		}
+		trading.Reset()
+	}()
+
+	//	交易系统和缓存的数据根据新的配置重新创建
+	trading.Reset()
+
+	if kind == KindSweep {
+		err = trading.TestAll(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return currentProgress(), nil
+	}
+
+	spec := request.Strategy
+	if request.Params != nil {
+		spec = request.Params.Spec()
+	}
+
+	return trading.TestStrategy(ctx, spec)
+}
@@ -0,0 +1,610 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/history"
+	"github.com/nzai/Tast/indicator"
+	"github.com/nzai/Tast/logging"
+	"github.com/nzai/Tast/metrics"
+	"github.com/nzai/Tast/peroidexterma"
+	"github.com/nzai/Tast/stock"
+	"github.com/nzai/Tast/trading"
+	"github.com/nzai/Tast/turtle"
+)
+
+const (
+	configSection           = "server"
+	configAddressKey        = "address"
+	configBenchmarkSection  = "benchmark"
+	configBenchmarkIndexKey = "index"
+	defaultAddress          = "127.0.0.1:8080"
+	maxRequestSize          = 1 << 20
+	shutdownTimeout         = time.Second * 10 //	关闭时等待正在处理的请求的时间
+	kindTurtle              = "turtle"
+	kindPeroidExterma       = "peroidexterma"
+)
+
+//	股票代码的格式，例如AAPL、BRK.B
+var codePattern = regexp.MustCompile(`^[A-Z][A-Z0-9]{0,5}([.\-][A-Z0-9]{1,2})?$`)
+
+//	HTTP服务，提供查询数据和启动回测的JSON接口
+//	GET  /api/stocks                         股票列表
+//	GET  /api/history?code=&from=&to=        每日历史，可以指定timeframe
+//	GET  /api/indicators?code=&name=&peroid= 指标，name为turtle、peroidexterma或技术指标的名称
+//	POST /api/backtests                      启动回测，请求体为JobRequest
+//	POST /api/sweeps                         启动海龟交易系统参数遍历
+//	GET  /api/jobs                           所有任务
+//	GET  /api/jobs/{id}                      任务状态及参数遍历的进度
+//	GET  /api/jobs/{id}/result               任务结果
+//	GET  /api/reports/{name}                 下载已保存的测试报告
+//	GET  /api/progress                       当前参数遍历的进度
+//	GET  /api/trades?code=&strategy=         用策略测试一只股票的交易记录，benchmarks=true时同时与基准比较
+//	GET  /metrics                            Prometheus格式的监控指标
+//	GET  /                                   网页界面
+type Server struct {
+	mux  *http.ServeMux
+	jobs *jobManager
+}
+
+//	ctx被取消时停止执行任务
+func New(ctx context.Context) *Server {
+
+	server := &Server{mux: http.NewServeMux(), jobs: newJobManager(ctx)}
+	server.mux.HandleFunc("/api/stocks", server.stocks)
+	server.mux.HandleFunc("/api/history", server.history)
+	server.mux.HandleFunc("/api/indicators", server.indicators)
+	server.mux.HandleFunc("/api/backtests", server.submit(KindBacktest))
+	server.mux.HandleFunc("/api/sweeps", server.submit(KindSweep))
+	server.mux.HandleFunc("/api/jobs", server.jobList)
+	server.mux.HandleFunc("/api/jobs/", server.job)
+	server.mux.HandleFunc("/api/reports/", server.report)
+	server.mux.HandleFunc("/api/progress", server.progress)
+	server.mux.HandleFunc("/api/trades", server.trades)
+	server.mux.Handle("/metrics", metrics.Handler())
+	server.mux.Handle("/", dashboard())
+
+	return server
+}
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mux.ServeHTTP(w, r)
+}
+
+//	在配置文件指定的地址上启动服务，address不为空时优先
+//	ctx被取消时停止接受新的请求，等待正在执行的任务保存进度后返回
+func Serve(ctx context.Context, address string) error {
+
+	if address == "" {
+		address = config.GetString(configSection, configAddressKey, defaultAddress)
+	}
+
+	logger := logging.Stage("server")
+	logger.Info("HTTP服务启动", "address", address)
+
+	server := New(ctx)
+	httpServer := &http.Server{Addr: address, Handler: server}
+
+	chanError := make(chan error, 1)
+	go func() {
+		chanError <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-chanError:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("HTTP服务开始关闭")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := httpServer.Shutdown(shutdownCtx)
+	<-server.jobs.done
+
+	logger.Info("HTTP服务已关闭")
+
+	return err
+}
+
+//	股票列表
+func (server *Server) stocks(w http.ResponseWriter, r *http.Request) {
+
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+
+	stocks, err := stock.GetAll()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	type item struct {
+		Code string `json:"code"`
+		Name string `json:"name"`
+	}
+
+	items := make([]item, 0, len(stocks))
+	for _, s := range stocks {
+		items = append(items, item{s.Code, s.EnglishName})
+	}
+
+	writeJSON(w, http.StatusOK, items)
+}
+
+//	K线
+type bar struct {
+	Date   string  `json:"date"`
+	Open   float64 `json:"open"`
+	High   float64 `json:"high"`
+	Low    float64 `json:"low"`
+	Close  float64 `json:"close"`
+	Volume int64   `json:"volume"`
+}
+
+//	股票的K线
+func (server *Server) history(w http.ResponseWriter, r *http.Request) {
+
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+
+	query, err := parseQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	histories, err := history.GetStockBars(query.code, query.timeframe)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	bars := make([]bar, 0, len(histories))
+	for _, h := range histories {
+		if query.contains(h.Date) {
+			bars = append(bars, bar{h.Date, h.Open, h.High, h.Low, h.Close, h.Volume})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, bars)
+}
+
+//	指标的一个数据点，无效的数值为null
+type point struct {
+	Date   string   `json:"date"`
+	Values []number `json:"values"`
+	Valid  bool     `json:"valid"`
+}
+
+//	指标序列
+type series struct {
+	Code    string   `json:"code"`
+	Name    string   `json:"name"`
+	Peroid  int      `json:"peroid"`
+	Columns []string `json:"columns"`
+	Points  []point  `json:"points"`
+}
+
+//	股票的指标
+func (server *Server) indicators(w http.ResponseWriter, r *http.Request) {
+
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+
+	query, err := parseQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	name := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("name")))
+	peroid := 0
+	if value := r.URL.Query().Get("peroid"); value != "" {
+		peroid, err = strconv.Atoi(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("周期%s必须是整数", value))
+			return
+		}
+	}
+
+	result, err := loadSeries(query, name, peroid, r.URL.Query().Get("estimator"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+//	读取指标序列
+func loadSeries(query *dataQuery, name string, peroid int, estimatorName string) (*series, error) {
+
+	result := &series{Code: query.code, Name: name, Peroid: peroid, Points: make([]point, 0)}
+	add := func(date string, valid bool, values ...float64) {
+		if !query.contains(date) {
+			return
+		}
+
+		numbers := make([]number, 0, len(values))
+		for _, value := range values {
+			numbers = append(numbers, number(value))
+		}
+
+		result.Points = append(result.Points, point{date, numbers, valid})
+	}
+
+	switch name {
+	case kindTurtle:
+		estimator, err := turtle.ParseEstimator(estimatorName)
+		if err != nil {
+			return nil, err
+		}
+
+		indexes, err := turtle.GetStockIndex(query.code, query.timeframe, estimator)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Columns = []string{"n", "tr"}
+		for _, index := range indexes[peroid] {
+			add(index.Date, index.Valid, index.N, index.TR)
+		}
+	case kindPeroidExterma:
+		indexes, err := peroidexterma.GetStockIndex(query.code, query.timeframe)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Columns = []string{"max", "min"}
+		for _, index := range indexes[peroid] {
+			add(index.Date, index.Valid, index.Max, index.Min)
+		}
+	default:
+		ind, err := indicator.Get(name)
+		if err != nil {
+			return nil, err
+		}
+
+		points, err := indicator.GetStockIndex(query.code, query.timeframe, ind)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Name, result.Columns = ind.Name(), ind.Columns()
+		for _, p := range points[peroid] {
+			add(p.Date, p.Valid, p.Values...)
+		}
+	}
+
+	return result, nil
+}
+
+//	提交任务
+func (server *Server) submit(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if !allow(w, r, http.MethodPost) {
+			return
+		}
+
+		var request JobRequest
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&request)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		job, err := server.jobs.submit(kind, request)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		w.Header().Set("Location", "/api/jobs/"+job.ID)
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
+
+//	所有任务
+func (server *Server) jobList(w http.ResponseWriter, r *http.Request) {
+
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, server.jobs.list())
+}
+
+//	任务状态或结果
+func (server *Server) job(w http.ResponseWriter, r *http.Request) {
+
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/")
+	job, result, found := server.jobs.get(parts[0])
+	if !found || len(parts) > 2 || (len(parts) == 2 && parts[1] != "result") {
+		writeError(w, http.StatusNotFound, fmt.Errorf("任务%s不存在", r.URL.Path))
+		return
+	}
+
+	if len(parts) == 1 {
+		writeJSON(w, http.StatusOK, job)
+		return
+	}
+
+	switch job.Status {
+	case JobDone:
+		writeJSON(w, http.StatusOK, result)
+	case JobFailed:
+		writeError(w, http.StatusConflict, errors.New(job.Error))
+	default:
+		writeError(w, http.StatusConflict, fmt.Errorf("任务%s尚未完成", job.ID))
+	}
+}
+
+//	下载已保存的测试报告
+func (server *Server) report(w http.ResponseWriter, r *http.Request) {
+
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/api/reports/")
+	found := false
+	for _, fileName := range trading.ReportFiles() {
+		found = found || fileName == name
+	}
+
+	if !found {
+		writeError(w, http.StatusNotFound, fmt.Errorf("报告%s不存在", name))
+		return
+	}
+
+	dataDir, err := config.GetDataDir()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	buffer, err := ioutil.ReadFile(filepath.Join(dataDir, name))
+	if os.IsNotExist(err) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("报告%s尚未生成", name))
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(buffer)
+}
+
+//	当前参数遍历的进度
+func (server *Server) progress(w http.ResponseWriter, r *http.Request) {
+
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+
+	progress := server.jobs.progress()
+	if progress == nil {
+		writeError(w, http.StatusNotFound, errors.New("参数遍历尚未开始"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, progress)
+}
+
+//	用策略测试一只股票，返回交易记录
+func (server *Server) trades(w http.ResponseWriter, r *http.Request) {
+
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+
+	query, err := parseQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	spec := r.URL.Query().Get("strategy")
+	err = checkStrategy(spec)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	strategy, err := trading.ParseStrategy(spec)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	//	回测使用全局的配置和交易系统，不能与修改配置的任务同时执行
+	var result *trading.TradingResult
+	status := http.StatusNotFound
+	err = server.jobs.exclusive(r.Context(), func() error {
+		var err error
+		result, err = trading.Backtest(query.code, strategy)
+		if err != nil {
+			return err
+		}
+
+		//	benchmarks=true时同时返回与基准的比较
+		if r.URL.Query().Get("benchmarks") == "true" {
+			status = http.StatusInternalServerError
+			return result.Compare()
+		}
+
+		return nil
+	})
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	trades := make([]trading.Trade, 0, len(result.Trades))
+	for _, trade := range result.Trades {
+		if query.contains(trade.EnterDate) || query.contains(trade.ExitDate) {
+			trades = append(trades, trade)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"strategy":      result.Strategy,
+		"profit":        result.Profit,
+		"profitPercent": result.ProfitPercent,
+		"trades":        trades,
+		"benchmarks":    result.Benchmarks,
+	})
+}
+
+//	查询数据的公共参数
+type dataQuery struct {
+	code      string
+	timeframe history.Timeframe
+	from, to  string
+}
+
+//	日期是否在查询范围内
+func (query *dataQuery) contains(date string) bool {
+	return (query.from == "" || date >= query.from) && (query.to == "" || date <= query.to)
+}
+
+//	解析code、timeframe、from、to参数，日期可以写作20060102或2006-01-02
+func parseQuery(r *http.Request) (*dataQuery, error) {
+
+	values := r.URL.Query()
+	query := &dataQuery{
+		code: strings.ToUpper(strings.TrimSpace(values.Get("code"))),
+		from: strings.Replace(values.Get("from"), "-", "", -1),
+		to:   strings.Replace(values.Get("to"), "-", "", -1),
+	}
+
+	if query.code == "" {
+		return nil, errors.New("必须指定股票代码code")
+	}
+
+	err := checkCode(query.code)
+	if err != nil {
+		return nil, err
+	}
+
+	if values.Get("timeframe") == "" {
+		query.timeframe, err = history.DefaultTimeframe()
+	} else {
+		query.timeframe, err = history.ParseTimeframe(values.Get("timeframe"))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return query, nil
+}
+
+//	只接受股票列表中的代码以及基准指数，代码用于拼接文件路径并可能触发下载
+func checkCode(code string) error {
+
+	if !codePattern.MatchString(code) {
+		return fmt.Errorf("股票代码%s格式不正确", code)
+	}
+
+	if code == strings.ToUpper(strings.TrimSpace(config.GetString(configBenchmarkSection, configBenchmarkIndexKey, ""))) {
+		return nil
+	}
+
+	codes, err := stock.GetCodes()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range codes {
+		if c == code {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("股票%s不在股票列表中", code)
+}
+
+//	规则策略从文件读取，通过接口只能使用不读取文件的策略
+func checkStrategy(spec string) error {
+
+	name := strings.ToLower(strings.TrimSpace(strings.SplitN(strings.TrimSpace(spec), ":", 2)[0]))
+	if name == "rule" {
+		return errors.New("接口不支持规则文件策略")
+	}
+
+	return nil
+}
+
+//	检查请求方法
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+
+	if r.Method == method {
+		return true
+	}
+
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("不支持%s方法", r.Method))
+
+	return false
+}
+
+//	输出JSON
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+
+	buffer, err := json.Marshal(value)
+	if err != nil {
+		logging.Stage("server").Error("输出JSON时发生错误", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(buffer)
+}
+
+//	输出错误
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+//	JSON中的数值，NaN和无穷大输出为null
+type number float64
+
+func (n number) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(n)) || math.IsInf(float64(n), 0) {
+		return []byte("null"), nil
+	}
+
+	return []byte(strconv.FormatFloat(float64(n), 'g', -1, 64)), nil
+}
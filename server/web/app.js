@@ -0,0 +1,315 @@
+'use strict';
+
+// 参数遍历的进度以及收益最高的参数组合
+let top = [];
+let sortKey = 'Profit';
+let sortAsc = false;
+let best = null;
+
+async function getJSON(url) {
+	const response = await fetch(url);
+	const body = await response.json();
+	if (!response.ok) {
+		throw new Error(body.error || response.statusText);
+	}
+	return body;
+}
+
+function parameterText(p) {
+	let text = `Holding ${p.Holding} N ${p.N} Enter ${p.Enter} Exit ${p.Exit} Stop ${p.Stop}`;
+	if (p.Trend) text += ` Trend ${p.Trend}`;
+	if (p.ADX) text += ` ADX ${p.ADX}`;
+	if (p.IndexTrend) text += ` IndexTrend ${p.IndexTrend}`;
+	if (p.VolatilityMin || p.VolatilityMax) text += ` Volatility ${p.VolatilityMin}-${p.VolatilityMax}`;
+	return text;
+}
+
+// 参数对应的策略描述，与TurtleTradingSystemParameter.Spec一致
+function parameterSpec(p) {
+	return 'turtle:' + [p.Holding, p.N, p.Enter, p.Exit, p.Stop, p.Trend, p.ADX, p.IndexTrend, p.VolatilityMin, p.VolatilityMax].join(':');
+}
+
+function money(value) {
+	return value.toFixed(2);
+}
+
+function percent(value) {
+	return (value * 100).toFixed(3) + '%';
+}
+
+async function refreshProgress() {
+	let progress;
+	try {
+		progress = await getJSON('api/progress');
+	} catch (error) {
+		document.getElementById('progress-text').textContent = error.message;
+		return;
+	}
+
+	const ratio = progress.calculatingAmount > 0 ? progress.calculatedAmount / progress.calculatingAmount : 0;
+	document.getElementById('progress-bar').style.width = (ratio * 100).toFixed(3) + '%';
+	document.getElementById('progress-text').textContent =
+		`${progress.calculatedAmount} / ${progress.calculatingAmount}，已用${progress.calculatedSeconds}秒，${progress.remainTips}`;
+	document.getElementById('current').textContent = parameterText(progress.current);
+	document.getElementById('current-profit').textContent = money(progress.currentProfit);
+	document.getElementById('best').textContent = parameterText(progress.best);
+	document.getElementById('best-profit').textContent = `${money(progress.bestProfit)} (${percent(progress.bestProfitPercent)})`;
+
+	best = progress.best;
+	const strategy = document.getElementById('strategy');
+	if (strategy.placeholder !== parameterSpec(best)) {
+		strategy.placeholder = parameterSpec(best);
+	}
+
+	top = progress.top || [];
+	renderTop();
+}
+
+function sortValue(result, key) {
+	switch (key) {
+	case 'Profit':
+	case 'ProfitPercent':
+	case 'Trades':
+	case 'WinRate':
+		return result[key];
+	case 'Volatility':
+		return result.Parameter.VolatilityMin * 1000 + result.Parameter.VolatilityMax;
+	default:
+		return result.Parameter[key];
+	}
+}
+
+function renderTop() {
+	const rows = top.slice().sort((a, b) => {
+		const difference = sortValue(a, sortKey) - sortValue(b, sortKey);
+		return sortAsc ? difference : -difference;
+	});
+
+	const body = document.querySelector('#top tbody');
+	body.innerHTML = '';
+	for (const result of rows) {
+		const p = result.Parameter;
+		const row = document.createElement('tr');
+		for (const value of [p.Holding, p.N, p.Enter, p.Exit, p.Stop, p.Trend, p.ADX, p.IndexTrend,
+			`${p.VolatilityMin}-${p.VolatilityMax}`, money(result.Profit), percent(result.ProfitPercent),
+			result.Trades, percent(result.WinRate)]) {
+			const cell = document.createElement('td');
+			cell.textContent = value;
+			row.appendChild(cell);
+		}
+		row.title = '点击在K线图中显示交易';
+		row.addEventListener('click', () => {
+			document.getElementById('strategy').value = parameterSpec(p);
+			document.getElementById('channel').value = p.Enter;
+			document.getElementById('n').value = p.N;
+			drawChart();
+		});
+		body.appendChild(row);
+	}
+
+	for (const th of document.querySelectorAll('#top th')) {
+		th.classList.toggle('asc', th.dataset.key === sortKey && sortAsc);
+		th.classList.toggle('desc', th.dataset.key === sortKey && !sortAsc);
+	}
+}
+
+for (const th of document.querySelectorAll('#top th')) {
+	th.addEventListener('click', () => {
+		sortAsc = th.dataset.key === sortKey ? !sortAsc : false;
+		sortKey = th.dataset.key;
+		renderTop();
+	});
+}
+
+async function loadStocks() {
+	const select = document.getElementById('code');
+	for (const stock of await getJSON('api/stocks')) {
+		const option = document.createElement('option');
+		option.value = stock.code;
+		option.textContent = `${stock.code} ${stock.name}`;
+		select.appendChild(option);
+	}
+}
+
+// 按日期索引指标的数值
+function byDate(series, column) {
+	const values = new Map();
+	for (const point of series.points) {
+		values.set(point.date, point.valid ? point.values[column] : null);
+	}
+	return values;
+}
+
+async function drawChart() {
+	const code = document.getElementById('code').value;
+	const from = document.getElementById('from').value.trim();
+	const to = document.getElementById('to').value.trim();
+	const channel = document.getElementById('channel').value;
+	const n = document.getElementById('n').value;
+	const strategyInput = document.getElementById('strategy');
+	const strategy = strategyInput.value.trim() || (best ? parameterSpec(best) : '');
+	const range = `code=${encodeURIComponent(code)}&from=${encodeURIComponent(from)}&to=${encodeURIComponent(to)}`;
+	const text = document.getElementById('chart-text');
+
+	let bars, extermas, turtles, trades = null;
+	try {
+		[bars, extermas, turtles] = await Promise.all([
+			getJSON(`api/history?${range}`),
+			getJSON(`api/indicators?${range}&name=peroidexterma&peroid=${channel}`),
+			getJSON(`api/indicators?${range}&name=turtle&peroid=${n}`),
+		]);
+		if (strategy) {
+			trades = await getJSON(`api/trades?${range}&strategy=${encodeURIComponent(strategy)}`);
+		}
+	} catch (error) {
+		text.textContent = error.message;
+		return;
+	}
+
+	// 没有指定范围时只显示最近一年
+	if (!from && !to) {
+		bars = bars.slice(-250);
+	}
+
+	text.textContent = trades ? `${trades.strategy} Profit ${money(trades.profit)} (${percent(trades.profitPercent)})` : '';
+	render(bars, byDate(extermas, 0), byDate(extermas, 1), byDate(turtles, 0), trades ? trades.trades : []);
+}
+
+function render(bars, upper, lower, ns, trades) {
+	const canvas = document.getElementById('chart');
+	const context = canvas.getContext('2d');
+	context.clearRect(0, 0, canvas.width, canvas.height);
+	if (bars.length === 0) {
+		return;
+	}
+
+	const margin = { left: 8, right: 64, top: 16, bottom: 24 };
+	const width = canvas.width - margin.left - margin.right;
+	const height = canvas.height - margin.top - margin.bottom;
+
+	// 价格范围包括K线、通道和N带
+	let min = Infinity, max = -Infinity;
+	const include = value => {
+		if (value !== null && value !== undefined && isFinite(value)) {
+			min = Math.min(min, value);
+			max = Math.max(max, value);
+		}
+	};
+	for (const bar of bars) {
+		include(bar.high);
+		include(bar.low);
+		include(upper.get(bar.date));
+		include(lower.get(bar.date));
+		const n = ns.get(bar.date);
+		if (n !== null && n !== undefined) {
+			include(bar.close + 2 * n);
+			include(bar.close - 2 * n);
+		}
+	}
+	const padding = (max - min) * 0.05 || 1;
+	min -= padding;
+	max += padding;
+
+	const step = width / bars.length;
+	const x = index => margin.left + step * (index + 0.5);
+	const y = price => margin.top + (max - price) / (max - min) * height;
+
+	// 价格刻度
+	context.fillStyle = '#666';
+	context.strokeStyle = '#eee';
+	context.font = '11px sans-serif';
+	for (let i = 0; i <= 5; i++) {
+		const price = min + (max - min) * i / 5;
+		context.beginPath();
+		context.moveTo(margin.left, y(price));
+		context.lineTo(margin.left + width, y(price));
+		context.stroke();
+		context.fillText(price.toFixed(2), margin.left + width + 4, y(price) + 4);
+	}
+
+	// 日期刻度
+	const labels = Math.max(1, Math.floor(bars.length / 8));
+	for (let index = 0; index < bars.length; index += labels) {
+		context.fillText(bars[index].date, x(index) - 24, canvas.height - 6);
+	}
+
+	// 指标曲线，无效的数值处断开
+	const line = (color, value) => {
+		context.strokeStyle = color;
+		context.beginPath();
+		let drawing = false;
+		bars.forEach((bar, index) => {
+			const v = value(bar);
+			if (v === null || v === undefined) {
+				drawing = false;
+				return;
+			}
+			if (drawing) {
+				context.lineTo(x(index), y(v));
+			} else {
+				context.moveTo(x(index), y(v));
+				drawing = true;
+			}
+		});
+		context.stroke();
+	};
+
+	const band = (bar, sign) => {
+		const n = ns.get(bar.date);
+		return n === null || n === undefined ? null : bar.close + sign * 2 * n;
+	};
+
+	line('#36c', bar => upper.get(bar.date));
+	line('#36c', bar => lower.get(bar.date));
+	line('#c90', bar => band(bar, 1));
+	line('#c90', bar => band(bar, -1));
+
+	// K线
+	const body = Math.max(1, step * 0.6);
+	bars.forEach((bar, index) => {
+		const color = bar.close >= bar.open ? '#3a7' : '#d44';
+		context.strokeStyle = color;
+		context.fillStyle = color;
+		context.beginPath();
+		context.moveTo(x(index), y(bar.high));
+		context.lineTo(x(index), y(bar.low));
+		context.stroke();
+		const top = y(Math.max(bar.open, bar.close));
+		context.fillRect(x(index) - body / 2, top, body, Math.max(1, y(Math.min(bar.open, bar.close)) - top));
+	});
+
+	// 交易标记
+	const positions = new Map(bars.map((bar, index) => [bar.date, index]));
+	const marker = (date, price, color, up) => {
+		const index = positions.get(date);
+		if (index === undefined) {
+			return;
+		}
+		const size = 6;
+		const tip = y(price) + (up ? size : -size);
+		context.fillStyle = color;
+		context.beginPath();
+		context.moveTo(x(index), tip);
+		context.lineTo(x(index) - size, tip + (up ? size * 1.5 : -size * 1.5));
+		context.lineTo(x(index) + size, tip + (up ? size * 1.5 : -size * 1.5));
+		context.closePath();
+		context.fill();
+	};
+	for (const trade of trades) {
+		marker(trade.EnterDate, trade.EnterPrice, '#090', true);
+		if (trade.ExitDate) {
+			marker(trade.ExitDate, trade.ExitPrice, '#c00', false);
+		}
+	}
+}
+
+document.getElementById('chart-form').addEventListener('submit', event => {
+	event.preventDefault();
+	drawChart();
+});
+
+loadStocks().then(drawChart).catch(error => {
+	document.getElementById('status').textContent = error.message;
+});
+refreshProgress();
+setInterval(refreshProgress, 2000);
@@ -2,16 +2,18 @@ package stock
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/nzai/Tast/atomicfile"
 	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/fetch"
+	"github.com/nzai/Tast/logging"
+	"github.com/nzai/Tast/metrics"
 )
 
 const (
@@ -29,19 +31,41 @@ type Stock struct {
 }
 
 //	更新股票列表
-func UpdateAll() error {
+func UpdateAll(ctx context.Context) error {
 
-	log.Println("开始更新股票列表")
+	logger := logging.Stage("stocks")
+	logger.Info("开始更新股票列表")
 	//	更新股票
-	_, err := GetAll()
+	_, err := getAll(ctx)
 
-	log.Println("股票列表更新结束")
+	logger.Info("股票列表更新结束")
 
 	return err
 }
 
+//	获取所有股票代码
+func GetCodes() ([]string, error) {
+
+	stocks, err := GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, len(stocks))
+	for _, s := range stocks {
+		codes = append(codes, s.Code)
+	}
+
+	return codes, nil
+}
+
 //	获取股票列表
 func GetAll() ([]Stock, error) {
+	return getAll(context.Background())
+}
+
+//	获取股票列表，股票列表文件不存在时下载，下载可以被ctx取消
+func getAll(ctx context.Context) ([]Stock, error) {
 
 	//	数据保存目录
 	dataDir, err := config.GetDataDir()
@@ -54,7 +78,7 @@ func GetAll() ([]Stock, error) {
 	_, err = os.Stat(filePath)
 	if os.IsNotExist(err) {
 		//	如果股票列表文件不存在，则从纳斯达克下载
-		stocks, err := downloadFromNasdaq100()
+		stocks, err := downloadFromNasdaq100(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -99,16 +123,16 @@ func GetAll() ([]Stock, error) {
 //	return stocks, nil
 //}
 
-func downloadFromNasdaq100() ([]Stock, error) {
+func downloadFromNasdaq100(ctx context.Context) ([]Stock, error) {
 
-	response, err := http.Get(nasdaq100Url)
+	fetcher, err := fetch.Default()
 	if err != nil {
 		return nil, err
 	}
-	defer response.Body.Close()
 
-	buffer, err := ioutil.ReadAll(response.Body)
+	buffer, err := fetcher.Get(ctx, nasdaq100Url)
 	if err != nil {
+		metrics.DownloadErrors.Inc("stocks")
 		return nil, err
 	}
 
@@ -120,6 +144,7 @@ func downloadFromNasdaq100() ([]Stock, error) {
 
 		parts := strings.Split(lines[index], ",")
 		if len(parts) < 2 {
+			metrics.ParseFailures.Inc("stocks")
 			return nil, errors.New("纳斯达克股票列表文件格式不正确")
 		}
 
@@ -136,7 +161,7 @@ func downloadFromNasdaq100() ([]Stock, error) {
 func save(stocks []Stock, filePath string) error {
 
 	//	打开文件
-	file, err := os.OpenFile(filePath, os.O_CREATE, 0x777)
+	file, err := atomicfile.Create(filePath)
 	if err != nil {
 		return err
 	}
@@ -152,7 +177,8 @@ func save(stocks []Stock, filePath string) error {
 			return err
 		}
 	}
-	return nil
+
+	return file.Commit()
 }
 
 //	读取
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/boltdb/bolt"
+)
+
+//	BoltDB嵌入式数据库存储
+//	每种数据一个bucket，其下每只股票一个子bucket
+//	键为2字节周期+8字节日期，值为依次排列的float64
+type boltStore struct {
+	db *bolt.DB
+}
+
+//	打开BoltDB存储
+func NewBoltStore(filePath string) (Store, error) {
+
+	db, err := bolt.Open(filePath, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltStore{db: db}, nil
+}
+
+func (store *boltStore) Exists(code, kind string) (bool, error) {
+
+	found := false
+	err := store.db.View(func(tx *bolt.Tx) error {
+		bucket := codeBucket(tx, code, kind)
+		if bucket != nil {
+			key, _ := bucket.Cursor().First()
+			found = key != nil
+		}
+
+		return nil
+	})
+
+	return found, err
+}
+
+func (store *boltStore) Load(code, kind string) ([]Record, error) {
+
+	records := make([]Record, 0)
+	err := store.db.View(func(tx *bolt.Tx) error {
+		bucket := codeBucket(tx, code, kind)
+		if bucket == nil {
+			return fmt.Errorf("没有找到股票%s的%s数据", code, kind)
+		}
+
+		return bucket.ForEach(func(key, value []byte) error {
+			record, err := decodeRecord(key, value)
+			if err != nil {
+				return err
+			}
+
+			records = append(records, record)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func (store *boltStore) Save(code, kind string, records []Record) error {
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		kindBucket, err := tx.CreateBucketIfNotExists([]byte(kind))
+		if err != nil {
+			return err
+		}
+
+		//	整体覆盖
+		if kindBucket.Bucket([]byte(code)) != nil {
+			err = kindBucket.DeleteBucket([]byte(code))
+			if err != nil {
+				return err
+			}
+		}
+
+		bucket, err := kindBucket.CreateBucket([]byte(code))
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			key, value, err := encodeRecord(record)
+			if err != nil {
+				return err
+			}
+
+			err = bucket.Put(key, value)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (store *boltStore) Remove(code, kind string) error {
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		kindBucket := tx.Bucket([]byte(kind))
+		if kindBucket == nil || kindBucket.Bucket([]byte(code)) == nil {
+			return nil
+		}
+
+		return kindBucket.DeleteBucket([]byte(code))
+	})
+}
+
+func (store *boltStore) Close() error {
+	return store.db.Close()
+}
+
+//	股票的数据bucket
+func codeBucket(tx *bolt.Tx, code, kind string) *bolt.Bucket {
+	kindBucket := tx.Bucket([]byte(kind))
+	if kindBucket == nil {
+		return nil
+	}
+
+	return kindBucket.Bucket([]byte(code))
+}
+
+//	编码记录
+func encodeRecord(record Record) ([]byte, []byte, error) {
+	if len(record.Date) != 8 {
+		return nil, nil, fmt.Errorf("日期格式不正确:%s", record.Date)
+	}
+
+	key := make([]byte, 10)
+	binary.BigEndian.PutUint16(key, uint16(record.Peroid))
+	copy(key[2:], record.Date)
+
+	value := make([]byte, len(record.Values)*8)
+	for index, v := range record.Values {
+		binary.BigEndian.PutUint64(value[index*8:], math.Float64bits(v))
+	}
+
+	return key, value, nil
+}
+
+//	解码记录
+func decodeRecord(key, value []byte) (Record, error) {
+	if len(key) != 10 || len(value)%8 != 0 {
+		return Record{}, errors.New("数据库记录格式不正确")
+	}
+
+	values := make([]float64, len(value)/8)
+	for index := range values {
+		values[index] = math.Float64frombits(binary.BigEndian.Uint64(value[index*8:]))
+	}
+
+	return Record{
+		Peroid: int(binary.BigEndian.Uint16(key)),
+		Date:   string(key[2:]),
+		Values: values,
+	}, nil
+}
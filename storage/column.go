@@ -0,0 +1,403 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"unsafe"
+
+	"github.com/nzai/Tast/atomicfile"
+)
+
+//	二进制列式存储，每只股票每种数据一个文件
+//	文件头:	"TAST" 版本 值宽度 周期数 日期数 列数 周期列表 日期列表(yyyymmdd)
+//	数据:		按列、周期、日期依次排列的定长float64或float32
+//	列式存储假定各周期的日期相同，没有记录的位置以NaN填充
+const (
+	columnMagic      = "TAST"
+	columnVersion    = 1
+	columnHeaderSize = 24
+	columnExtension  = ".col"
+)
+
+type columnStore struct {
+	dataDir string
+	width   int
+}
+
+//	新建列式存储，width为指标数值占用的字节数(8或4)，每日历史总是使用8字节
+func NewColumnStore(dataDir string, width int) (Store, error) {
+	if width != 8 && width != 4 {
+		return nil, fmt.Errorf("列式存储不支持宽度为%d的值", width)
+	}
+
+	return &columnStore{dataDir: dataDir, width: width}, nil
+}
+
+//	数据文件路径
+func (store *columnStore) filePath(code, kind string) string {
+	return filepath.Join(store.dataDir, code, kind+columnExtension)
+}
+
+func (store *columnStore) Exists(code, kind string) (bool, error) {
+
+	_, err := os.Stat(store.filePath(code, kind))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (store *columnStore) Load(code, kind string) ([]Record, error) {
+
+	file, err := OpenColumnFile(store.filePath(code, kind))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	periods, dates := file.Periods(), file.Dates()
+	columns := file.ColumnCount()
+
+	//	所有记录的值共用一块内存
+	values := make([]float64, len(periods)*len(dates)*columns)
+	records := make([]Record, 0, len(periods)*len(dates))
+	for periodIndex, peroid := range periods {
+
+		for column := 0; column < columns; column++ {
+			series := file.Column(column, peroid)
+			for dateIndex, value := range series {
+				values[(periodIndex*len(dates)+dateIndex)*columns+column] = value
+			}
+		}
+
+		for dateIndex, date := range dates {
+			offset := (periodIndex*len(dates) + dateIndex) * columns
+			records = append(records, Record{
+				Peroid: peroid,
+				Date:   date,
+				Values: values[offset : offset+columns : offset+columns],
+			})
+		}
+	}
+
+	return records, nil
+}
+
+//	直接引用映射的内存，不复制数据
+func (store *columnStore) LoadColumns(code, kind string, fn func(peroid int, dates []string, columns [][]float64) error) error {
+
+	file, err := OpenColumnFile(store.filePath(code, kind))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	columns := make([][]float64, file.ColumnCount())
+	for _, peroid := range file.Periods() {
+		for column := range columns {
+			columns[column] = file.Column(column, peroid)
+		}
+
+		err = fn(peroid, file.Dates(), columns)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (store *columnStore) Save(code, kind string, records []Record) error {
+
+	//	收集周期、日期和列数
+	periodSet, dateSet := make(map[int]bool), make(map[string]bool)
+	columns := 0
+	for _, record := range records {
+		periodSet[record.Peroid] = true
+		dateSet[record.Date] = true
+		if len(record.Values) > columns {
+			columns = len(record.Values)
+		}
+	}
+
+	periods := make([]int, 0, len(periodSet))
+	for peroid := range periodSet {
+		periods = append(periods, peroid)
+	}
+	sort.Ints(periods)
+
+	dates := make([]string, 0, len(dateSet))
+	for date := range dateSet {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+
+	periodIndexes := make(map[int]int, len(periods))
+	for index, peroid := range periods {
+		periodIndexes[peroid] = index
+	}
+
+	dateIndexes := make(map[string]int, len(dates))
+	for index, date := range dates {
+		dateIndexes[date] = index
+	}
+
+	//	没有记录的位置用NaN填充
+	data := make([]float64, columns*len(periods)*len(dates))
+	for index := range data {
+		data[index] = math.NaN()
+	}
+
+	for _, record := range records {
+		for column, value := range record.Values {
+			data[(column*len(periods)+periodIndexes[record.Peroid])*len(dates)+dateIndexes[record.Date]] = value
+		}
+	}
+
+	dir := filepath.Join(store.dataDir, code)
+	_, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		err = os.Mkdir(dir, 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	//	float32不能精确表示成交量和价格，只用于指标
+	width := store.width
+	if IsDailyKind(kind) {
+		width = 8
+	}
+
+	file, err := atomicfile.Create(store.filePath(code, kind))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+
+	header := make([]byte, columnHeaderSize)
+	copy(header, columnMagic)
+	binary.LittleEndian.PutUint32(header[4:], columnVersion)
+	binary.LittleEndian.PutUint32(header[8:], uint32(width))
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(periods)))
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(dates)))
+	binary.LittleEndian.PutUint32(header[20:], uint32(columns))
+	_, err = writer.Write(header)
+	if err != nil {
+		return err
+	}
+
+	buffer := make([]byte, 8)
+	for _, peroid := range periods {
+		binary.LittleEndian.PutUint32(buffer, uint32(int32(peroid)))
+		_, err = writer.Write(buffer[:4])
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, date := range dates {
+		value, err := strconv.ParseInt(date, 10, 32)
+		if err != nil {
+			return fmt.Errorf("日期格式不正确:%s", date)
+		}
+
+		binary.LittleEndian.PutUint32(buffer, uint32(value))
+		_, err = writer.Write(buffer[:4])
+		if err != nil {
+			return err
+		}
+	}
+
+	//	数据区按8字节对齐，以便映射后直接作为float64读取
+	_, err = writer.Write(make([]byte, columnPadding(len(periods), len(dates))))
+	if err != nil {
+		return err
+	}
+
+	for _, value := range data {
+		if width == 8 {
+			binary.LittleEndian.PutUint64(buffer, math.Float64bits(value))
+		} else {
+			binary.LittleEndian.PutUint32(buffer, math.Float32bits(float32(value)))
+		}
+
+		_, err = writer.Write(buffer[:width])
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	return file.Commit()
+}
+
+func (store *columnStore) Remove(code, kind string) error {
+
+	err := os.Remove(store.filePath(code, kind))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (store *columnStore) Close() error {
+	return nil
+}
+
+//	数据区之前的填充字节数
+func columnPadding(periodCount, dateCount int) int {
+	return (8 - (columnHeaderSize+(periodCount+dateCount)*4)%8) % 8
+}
+
+//	内存映射的列式数据文件
+type ColumnFile struct {
+	data    []byte
+	width   int
+	periods []int
+	dates   []string
+	columns int
+	offset  int
+	unmap   func() error
+}
+
+//	以内存映射方式打开列式数据文件
+func OpenColumnFile(filePath string) (*ColumnFile, error) {
+
+	data, unmap, err := mmapFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := parseColumnFile(data)
+	if err != nil {
+		unmap()
+		return nil, fmt.Errorf("列式数据文件%s格式不正确:%v", filePath, err)
+	}
+	file.unmap = unmap
+
+	return file, nil
+}
+
+//	解析文件头
+func parseColumnFile(data []byte) (*ColumnFile, error) {
+	if len(data) < columnHeaderSize || string(data[:4]) != columnMagic {
+		return nil, errors.New("文件头不正确")
+	}
+
+	if binary.LittleEndian.Uint32(data[4:]) != columnVersion {
+		return nil, errors.New("版本不正确")
+	}
+
+	width := int(binary.LittleEndian.Uint32(data[8:]))
+	periodCount := int(binary.LittleEndian.Uint32(data[12:]))
+	dateCount := int(binary.LittleEndian.Uint32(data[16:]))
+	columns := int(binary.LittleEndian.Uint32(data[20:]))
+	if width != 8 && width != 4 {
+		return nil, errors.New("值宽度不正确")
+	}
+
+	offset := columnHeaderSize + (periodCount+dateCount)*4 + columnPadding(periodCount, dateCount)
+	if len(data) != offset+columns*periodCount*dateCount*width {
+		return nil, errors.New("文件长度不正确")
+	}
+
+	periods := make([]int, periodCount)
+	for index := range periods {
+		periods[index] = int(int32(binary.LittleEndian.Uint32(data[columnHeaderSize+index*4:])))
+	}
+
+	dates := make([]string, dateCount)
+	for index := range dates {
+		dates[index] = strconv.Itoa(int(binary.LittleEndian.Uint32(data[columnHeaderSize+(periodCount+index)*4:])))
+	}
+
+	return &ColumnFile{
+		data:    data,
+		width:   width,
+		periods: periods,
+		dates:   dates,
+		columns: columns,
+		offset:  offset,
+	}, nil
+}
+
+//	周期列表
+func (file *ColumnFile) Periods() []int {
+	return file.periods
+}
+
+//	日期列表
+func (file *ColumnFile) Dates() []string {
+	return file.dates
+}
+
+//	列数
+func (file *ColumnFile) ColumnCount() int {
+	return file.columns
+}
+
+//	某一列在某一周期下按日期排列的值，周期不存在时返回nil
+//	float64文件直接引用映射的内存，在Close之后不能再使用
+func (file *ColumnFile) Column(column, peroid int) []float64 {
+
+	periodIndex := sort.SearchInts(file.periods, peroid)
+	if column < 0 || column >= file.columns || periodIndex >= len(file.periods) || file.periods[periodIndex] != peroid {
+		return nil
+	}
+
+	count := len(file.dates)
+	if count == 0 {
+		return []float64{}
+	}
+
+	start := file.offset + (column*len(file.periods)+periodIndex)*count*file.width
+	if file.width == 8 && nativeLittleEndian {
+		return unsafe.Slice((*float64)(unsafe.Pointer(&file.data[start])), count)
+	}
+
+	values := make([]float64, count)
+	for index := range values {
+		position := start + index*file.width
+		if file.width == 8 {
+			values[index] = math.Float64frombits(binary.LittleEndian.Uint64(file.data[position:]))
+		} else {
+			values[index] = float64(math.Float32frombits(binary.LittleEndian.Uint32(file.data[position:])))
+		}
+	}
+
+	return values
+}
+
+//	关闭文件
+func (file *ColumnFile) Close() error {
+	if file.unmap == nil {
+		return nil
+	}
+
+	err := file.unmap()
+	file.unmap = nil
+
+	return err
+}
+
+//	本机是否为小端字节序
+var nativeLittleEndian = func() bool {
+	value := uint16(1)
+	return *(*byte)(unsafe.Pointer(&value)) == 1
+}()
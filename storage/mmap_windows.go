@@ -0,0 +1,51 @@
+//go:build windows
+
+package storage
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+//	以只读方式映射整个文件
+func mmapFile(filePath string) ([]byte, func() error, error) {
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	size := info.Size()
+	if size == 0 {
+		return []byte{}, func() error { return nil }, nil
+	}
+
+	mapping, err := syscall.CreateFileMapping(syscall.Handle(file.Fd()), nil, syscall.PAGE_READONLY, uint32(size>>32), uint32(size), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	address, err := syscall.MapViewOfFile(mapping, syscall.FILE_MAP_READ, 0, 0, uintptr(size))
+	if err != nil {
+		syscall.CloseHandle(mapping)
+		return nil, nil, err
+	}
+
+	//	映射地址位于进程堆之外，不受垃圾回收影响
+	pointer := *(*unsafe.Pointer)(unsafe.Pointer(&address))
+	data := unsafe.Slice((*byte)(pointer), int(size))
+	unmap := func() error {
+		err := syscall.UnmapViewOfFile(address)
+		syscall.CloseHandle(mapping)
+		return err
+	}
+
+	return data, unmap, nil
+}
@@ -0,0 +1,298 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/nzai/Tast/config"
+)
+
+const (
+	configSection    = "storage"
+	configEngineKey  = "engine"
+	configBoltKey    = "boltfile"
+	configWidthKey   = "columnwidth"
+	engineText       = "text"
+	engineBolt       = "bolt"
+	engineColumn     = "column"
+	defaultBoltFile  = "Tast.db"
+	defaultEngine    = engineText
+	dailyValuesCount = 5
+)
+
+//	数据种类
+const (
+	KindDaily         = "Daily"         //	每日历史
+	KindTurtle        = "Turtle"        //	海龟指标
+	KindPeroidExterma = "PeroidExterma" //	区间极值指标
+	KindQuarantine    = "Quarantine"    //	被隔离的每日历史
+)
+
+//	一条记录，以股票代码、周期和日期定位
+//	每日历史的周期为0，Values依次为Open、Close、High、Low、Volume
+type Record struct {
+	Peroid int
+	Date   string
+	Values []float64
+}
+
+//	数据存储
+type Store interface {
+	//	是否已经保存了股票的某种数据
+	Exists(code, kind string) (bool, error)
+	//	读取股票的某种数据，按照周期、日期正序排列
+	Load(code, kind string) ([]Record, error)
+	//	整体覆盖保存股票的某种数据
+	Save(code, kind string, records []Record) error
+	//	删除股票的某种数据，数据不存在时不报错
+	Remove(code, kind string) error
+	//	关闭存储
+	Close() error
+}
+
+//	可以按列读取的存储，读取时不必为每条记录复制数据
+type ColumnLoader interface {
+	//	读取股票的某种数据，按周期正序对每个周期调用一次fn，columns[i]为第i列按日期排列的值
+	//	dates和columns可能直接引用存储的内存，只在fn执行期间有效
+	LoadColumns(code, kind string, fn func(peroid int, dates []string, columns [][]float64) error) error
+}
+
+//	按列读取股票的某种数据，存储不支持按列读取时由Load的结果按周期分组
+func LoadColumns(store Store, code, kind string, fn func(peroid int, dates []string, columns [][]float64) error) error {
+
+	loader, ok := store.(ColumnLoader)
+	if ok {
+		return loader.LoadColumns(code, kind, fn)
+	}
+
+	records, err := store.Load(code, kind)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(records); {
+		end := start
+		count := 0
+		for end < len(records) && records[end].Peroid == records[start].Peroid {
+			if len(records[end].Values) > count {
+				count = len(records[end].Values)
+			}
+			end++
+		}
+
+		dates := make([]string, end-start)
+		columns := make([][]float64, count)
+		for column := range columns {
+			columns[column] = make([]float64, end-start)
+		}
+
+		for index, record := range records[start:end] {
+			dates[index] = record.Date
+			for column := range columns {
+				if column < len(record.Values) {
+					columns[column][index] = record.Values[column]
+				} else {
+					columns[column][index] = math.NaN()
+				}
+			}
+		}
+
+		err = fn(records[start].Peroid, dates, columns)
+		if err != nil {
+			return err
+		}
+
+		start = end
+	}
+
+	return nil
+}
+
+var (
+	defaultStore Store
+	defaultMutex sync.Mutex
+)
+
+//	根据配置文件打开默认存储
+func Default() (Store, error) {
+
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+
+	if defaultStore != nil {
+		return defaultStore, nil
+	}
+
+	//	数据保存目录
+	dataDir, err := config.GetDataDir()
+	if err != nil {
+		return nil, err
+	}
+
+	engine := config.GetString(configSection, configEngineKey, defaultEngine)
+	switch engine {
+	case engineText:
+		defaultStore = NewTextStore(dataDir)
+	case engineBolt:
+		filePath := config.GetString(configSection, configBoltKey, filepath.Join(dataDir, defaultBoltFile))
+		defaultStore, err = NewBoltStore(filePath)
+		if err != nil {
+			return nil, err
+		}
+	case engineColumn:
+		//	值宽度为64位或32位
+		width, err := strconv.Atoi(config.GetString(configSection, configWidthKey, "64"))
+		if err != nil {
+			return nil, err
+		}
+
+		defaultStore, err = NewColumnStore(dataDir, width/8)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("不支持的存储引擎:%s", engine)
+	}
+
+	return defaultStore, nil
+}
+
+//	关闭默认存储
+func Close() error {
+
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+
+	if defaultStore == nil {
+		return nil
+	}
+
+	err := defaultStore.Close()
+	defaultStore = nil
+
+	return err
+}
+
+//	在两个存储之间复制数据，用于文本格式的导入导出
+func Copy(src, dst Store, codes []string, kinds []string) error {
+	_, err := copyStore(src, dst, codes, kinds)
+	return err
+}
+
+//	复制数据，返回复制了的数据在版本表中的键
+func copyStore(src, dst Store, codes []string, kinds []string) ([]string, error) {
+
+	copied := make([]string, 0)
+	for _, code := range codes {
+		for _, kind := range kinds {
+
+			found, err := src.Exists(code, kind)
+			if err != nil {
+				return copied, err
+			}
+
+			if !found {
+				continue
+			}
+
+			records, err := src.Load(code, kind)
+			if err != nil {
+				return copied, err
+			}
+
+			err = dst.Save(code, kind, records)
+			if err != nil {
+				return copied, err
+			}
+
+			copied = append(copied, versionKey(code, kind))
+		}
+	}
+
+	return copied, nil
+}
+
+//	指标种类
+var indicatorKinds = []string{KindTurtle, KindPeroidExterma}
+
+//	K线周期，与history.Timeframe对应
+var timeframes = []string{"daily", "weekly", "monthly"}
+
+//	注册新的指标种类
+func RegisterIndicatorKind(kind string) {
+	for _, k := range indicatorKinds {
+		if k == kind {
+			return
+		}
+	}
+
+	indicatorKinds = append(indicatorKinds, kind)
+}
+
+//	所有K线周期的指标种类
+func IndicatorKinds() []string {
+	kinds := make([]string, 0, len(indicatorKinds)*len(timeframes))
+	for _, kind := range indicatorKinds {
+		for _, timeframe := range timeframes {
+			kinds = append(kinds, TimeframeKind(kind, timeframe))
+		}
+	}
+
+	return kinds
+}
+
+//	指标在某个K线周期下的种类，日线沿用原来的种类
+func TimeframeKind(kind, timeframe string) string {
+	if timeframe == "" || timeframe == timeframes[0] {
+		return kind
+	}
+
+	return kind + "." + timeframe
+}
+
+//	所有种类
+func AllKinds() []string {
+	return append([]string{KindDaily, KindQuarantine}, IndicatorKinds()...)
+}
+
+//	是否为每日历史格式的数据
+func IsDailyKind(kind string) bool {
+	return kind == KindDaily || kind == KindQuarantine
+}
+
+//	从指定目录导入文本格式的数据到默认存储
+func Import(dir string, codes []string) error {
+
+	store, err := Default()
+	if err != nil {
+		return err
+	}
+
+	//	已经导入的数据即使出错也要记录版本，否则会被当作当前版本
+	copied, err := copyStore(NewTextStore(dir), store, codes, AllKinds())
+	versionErr := importVersions(dir, copied)
+	if err != nil {
+		return err
+	}
+
+	return versionErr
+}
+
+//	将默认存储中的数据导出到指定目录，保存为文本格式
+func Export(dir string, codes []string) error {
+
+	store, err := Default()
+	if err != nil {
+		return err
+	}
+
+	copied, err := copyStore(store, NewTextStore(dir), codes, AllKinds())
+	if err != nil {
+		return err
+	}
+
+	return exportVersions(dir, copied)
+}
@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nzai/Tast/atomicfile"
+)
+
+//	制表符分隔的文本文件存储，每只股票每种数据一个文件
+//	每日历史:	日期 Open Close High Low Volume 前一交易日
+//	指标:		周期 日期 值...
+type textStore struct {
+	dataDir string
+}
+
+//	新建文本文件存储
+func NewTextStore(dataDir string) Store {
+	return &textStore{dataDir: dataDir}
+}
+
+//	数据文件路径
+func (store *textStore) filePath(code, kind string) string {
+	return filepath.Join(store.dataDir, code, kind+".txt")
+}
+
+func (store *textStore) Exists(code, kind string) (bool, error) {
+
+	_, err := os.Stat(store.filePath(code, kind))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (store *textStore) Load(code, kind string) ([]Record, error) {
+
+	file, err := os.Open(store.filePath(code, kind))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	records := make([]Record, 0)
+	for scanner.Scan() {
+		parts := strings.Split(scanner.Text(), "\t")
+
+		var record Record
+		if IsDailyKind(kind) {
+			record, err = parseDailyLine(parts)
+		} else {
+			record, err = parseIndexLine(parts)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
+
+//	解析每日历史
+func parseDailyLine(parts []string) (Record, error) {
+	if len(parts) != dailyValuesCount+2 {
+		return Record{}, errors.New("股票每日历史文件格式不正确")
+	}
+
+	values := make([]float64, dailyValuesCount)
+	for index := range values {
+		value, err := strconv.ParseFloat(parts[index+1], 64)
+		if err != nil {
+			return Record{}, err
+		}
+
+		values[index] = value
+	}
+
+	return Record{Date: parts[0], Values: values}, nil
+}
+
+//	解析指标
+func parseIndexLine(parts []string) (Record, error) {
+	if len(parts) < 3 {
+		return Record{}, errors.New("指标文件格式不正确")
+	}
+
+	peroid, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return Record{}, err
+	}
+
+	values := make([]float64, len(parts)-2)
+	for index := range values {
+		value, err := strconv.ParseFloat(parts[index+2], 64)
+		if err != nil {
+			return Record{}, err
+		}
+
+		values[index] = value
+	}
+
+	return Record{Peroid: peroid, Date: parts[1], Values: values}, nil
+}
+
+func (store *textStore) Save(code, kind string, records []Record) error {
+
+	dir := filepath.Join(store.dataDir, code)
+	_, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		err = os.Mkdir(dir, 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	//	打开文件
+	file, err := atomicfile.Create(store.filePath(code, kind))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for index, record := range records {
+
+		var line string
+		if IsDailyKind(kind) {
+			if len(record.Values) != dailyValuesCount {
+				return fmt.Errorf("股票%s %s的每日历史数据不完整", code, record.Date)
+			}
+
+			prevDate := ""
+			if index > 0 {
+				prevDate = records[index-1].Date
+			}
+
+			line = fmt.Sprintf("%s\t%.6f\t%.6f\t%.6f\t%.6f\t%d\t%s\n",
+				record.Date,
+				record.Values[0],
+				record.Values[1],
+				record.Values[2],
+				record.Values[3],
+				int64(record.Values[4]),
+				prevDate)
+		} else {
+			values := make([]string, 0, len(record.Values)+2)
+			values = append(values, strconv.Itoa(record.Peroid), record.Date)
+			for _, value := range record.Values {
+				values = append(values, fmt.Sprintf("%.6f", value))
+			}
+
+			line = strings.Join(values, "\t") + "\n"
+		}
+
+		_, err = writer.WriteString(line)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	return file.Commit()
+}
+
+func (store *textStore) Remove(code, kind string) error {
+
+	err := os.Remove(store.filePath(code, kind))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (store *textStore) Close() error {
+	return nil
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nzai/Tast/atomicfile"
+	"github.com/nzai/Tast/config"
+)
+
+//	计算方法改变后，之前保存的数据需要重新计算
+//	各股票每种数据的格式版本记录在数据目录的Versions.txt中，每行为"代码	种类	版本"
+//	导出时版本表随数据一起写入导出目录，导入时再从中读取
+const versionsFileName = "Versions.txt"
+
+var (
+	versions        map[string]int
+	versionsPath    string
+	versionsModTime time.Time
+	versionsMutex   sync.Mutex
+)
+
+//	版本表中的键
+func versionKey(code, kind string) string {
+	return code + "\t" + kind
+}
+
+//	读取数据目录的版本表，数据目录改变或者文件被其他进程修改时重新读取，调用时必须持有versionsMutex
+func loadVersions() error {
+
+	dataDir, err := config.GetDataDir()
+	if err != nil {
+		return err
+	}
+
+	filePath := filepath.Join(dataDir, versionsFileName)
+	var modTime time.Time
+	info, err := os.Stat(filePath)
+	if err == nil {
+		modTime = info.ModTime()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if versions != nil && versionsPath == filePath && versionsModTime.Equal(modTime) {
+		return nil
+	}
+
+	loaded, err := readVersions(filePath)
+	if err != nil {
+		return err
+	}
+
+	versions, versionsPath, versionsModTime = loaded, filePath, modTime
+
+	return nil
+}
+
+//	保存数据目录的版本表，调用时必须持有versionsMutex
+func saveVersions() error {
+
+	err := writeVersions(versionsPath, versions)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(versionsPath)
+	if err != nil {
+		return err
+	}
+	versionsModTime = info.ModTime()
+
+	return nil
+}
+
+//	读取版本文件，文件不存在时返回空表
+func readVersions(filePath string) (map[string]int, error) {
+
+	loaded := make(map[string]int)
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return loaded, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.Split(scanner.Text(), "\t")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("版本文件%s格式不正确:%s", filePath, scanner.Text())
+		}
+
+		version, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("版本文件%s格式不正确:%s", filePath, scanner.Text())
+		}
+
+		loaded[versionKey(parts[0], parts[1])] = version
+	}
+
+	return loaded, scanner.Err()
+}
+
+//	写入版本文件
+func writeVersions(filePath string, table map[string]int) error {
+
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	file, err := atomicfile.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for _, key := range keys {
+		fmt.Fprintf(writer, "%s\t%d\n", key, table[key])
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	return file.Commit()
+}
+
+//	股票的某种数据存在并且由当前版本的方法计算，没有记录版本的数据视为旧版本
+func Current(store Store, code, kind string, version int) (bool, error) {
+
+	found, err := store.Exists(code, kind)
+	if err != nil || !found {
+		return false, err
+	}
+
+	versionsMutex.Lock()
+	defer versionsMutex.Unlock()
+
+	err = loadVersions()
+	if err != nil {
+		return false, err
+	}
+
+	return versions[versionKey(code, kind)] == version, nil
+}
+
+//	保存股票的某种数据，并记录计算方法的版本
+//	保存数据和更新版本表都在versionsMutex中进行，同时保存的多只股票不会丢失彼此的版本
+func SaveVersion(store Store, code, kind string, records []Record, version int) error {
+
+	versionsMutex.Lock()
+	defer versionsMutex.Unlock()
+
+	err := store.Save(code, kind, records)
+	if err != nil {
+		return err
+	}
+
+	//	写入前重新检查文件，保留其他进程记录的版本
+	err = loadVersions()
+	if err != nil {
+		return err
+	}
+
+	key := versionKey(code, kind)
+	if versions[key] == version {
+		return nil
+	}
+	versions[key] = version
+
+	return saveVersions()
+}
+
+//	将数据目录中已导出数据的版本写入dir的版本文件，保留其中其他数据的版本
+func exportVersions(dir string, keys []string) error {
+
+	versionsMutex.Lock()
+	defer versionsMutex.Unlock()
+
+	err := loadVersions()
+	if err != nil {
+		return err
+	}
+
+	filePath := filepath.Join(dir, versionsFileName)
+	exported, err := readVersions(filePath)
+	if err != nil {
+		return err
+	}
+
+	copyVersions(versions, exported, keys)
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	return writeVersions(filePath, exported)
+}
+
+//	从dir的版本文件读取已导入数据的版本，没有记录版本的数据视为旧版本
+func importVersions(dir string, keys []string) error {
+
+	imported, err := readVersions(filepath.Join(dir, versionsFileName))
+	if err != nil {
+		return err
+	}
+
+	versionsMutex.Lock()
+	defer versionsMutex.Unlock()
+
+	err = loadVersions()
+	if err != nil {
+		return err
+	}
+
+	copyVersions(imported, versions, keys)
+
+	return saveVersions()
+}
+
+//	复制数据的版本，源中没有记录的版本从目标中删除
+func copyVersions(src, dst map[string]int, keys []string) {
+	for _, key := range keys {
+		version, found := src[key]
+		if found {
+			dst[key] = version
+		} else {
+			delete(dst, key)
+		}
+	}
+}
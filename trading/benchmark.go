@@ -0,0 +1,394 @@
+package trading
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/nzai/Tast/calendar"
+	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/history"
+)
+
+const (
+	configBenchmarkSection = "benchmark"
+	configBenchmarkIndex   = "index"
+	tradingDaysPerYear     = 252
+)
+
+//	按日期排列的权益序列
+type Series struct {
+	Name   string
+	Dates  []string
+	Values []float64
+}
+
+//	与基准比较的结果
+type BenchmarkMetrics struct {
+	Benchmark       string
+	Return          float64 //	策略的总收益率
+	BenchmarkReturn float64 //	基准的总收益率
+	ExcessReturn    float64 //	超额收益率
+	Alpha           float64 //	年化alpha
+	Beta            float64
+	Correlation     float64 //	日收益率相关系数
+	TrackingError   float64 //	年化跟踪误差
+}
+
+func (metrics BenchmarkMetrics) String() string {
+	return fmt.Sprintf("%s Return = %.3f%% BenchmarkReturn = %.3f%% ExcessReturn = %.3f%% Alpha = %.3f%% Beta = %.3f Correlation = %.3f TrackingError = %.3f%%",
+		metrics.Benchmark,
+		metrics.Return*100,
+		metrics.BenchmarkReturn*100,
+		metrics.ExcessReturn*100,
+		metrics.Alpha*100,
+		metrics.Beta,
+		metrics.Correlation,
+		metrics.TrackingError*100)
+}
+
+var (
+	benchmarkCache = make(map[string]*Series)
+	benchmarkMutex sync.Mutex
+)
+
+//	单只股票的测试结果与买入持有、等权组合及指数进行比较
+func compareStock(result *TradingResult) ([]BenchmarkMetrics, error) {
+
+	buyAndHold, err := getBuyAndHold(result.Code)
+	if err != nil {
+		return nil, err
+	}
+
+	benchmarks, err := getCommonBenchmarks()
+	if err != nil {
+		return nil, err
+	}
+
+	benchmarks = append([]*Series{buyAndHold}, benchmarks...)
+
+	list := make([]BenchmarkMetrics, 0, len(benchmarks))
+	for _, benchmark := range benchmarks {
+		list = append(list, compare(result.Equity, *benchmark))
+	}
+
+	return list, nil
+}
+
+//	一组参数在所有股票上的测试结果与等权组合及指数进行比较
+func compareAggregate(results []*TradingResult) ([]BenchmarkMetrics, error) {
+	if len(results) == 0 {
+		return nil, nil
+	}
+
+	list := make([]Series, 0, len(results))
+	for _, result := range results {
+		list = append(list, result.Equity)
+	}
+
+	//	所有股票的权益合计后按股票数量折算
+	strategy, err := sumSeries("Strategy", list, results[0].StartAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	scale := 1 / float64(len(results))
+	for index := range strategy.Values {
+		strategy.Values[index] *= scale
+	}
+
+	benchmarks, err := getCommonBenchmarks()
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make([]BenchmarkMetrics, 0, len(benchmarks))
+	for _, benchmark := range benchmarks {
+		metrics = append(metrics, compare(*strategy, *benchmark))
+	}
+
+	return metrics, nil
+}
+
+//	所有测试共用的基准：等权买入持有组合及指数(如果配置了的话)
+func getCommonBenchmarks() ([]*Series, error) {
+
+	equalWeight, err := getEqualWeight()
+	if err != nil {
+		return nil, err
+	}
+
+	benchmarks := []*Series{equalWeight}
+
+	indexCode := config.GetString(configBenchmarkSection, configBenchmarkIndex, "")
+	if indexCode != "" {
+		index, err := getBuyAndHold(indexCode)
+		if err != nil {
+			return nil, err
+		}
+
+		benchmarks = append(benchmarks, index)
+	}
+
+	return benchmarks, nil
+}
+
+//	买入持有一只股票(或指数)的权益序列
+func getBuyAndHold(code string) (*Series, error) {
+
+	key := "BuyAndHold:" + code
+
+	benchmarkMutex.Lock()
+	series, found := benchmarkCache[key]
+	benchmarkMutex.Unlock()
+	if found {
+		return series, nil
+	}
+
+	histories, err := history.GetStockDailyHistory(code)
+	if err != nil {
+		return nil, err
+	}
+
+	system, err := currentSystem()
+	if err != nil {
+		return nil, err
+	}
+
+	series = &Series{Name: "BuyAndHold " + code, Dates: make([]string, 0), Values: make([]float64, 0)}
+	var shares, cash float64
+	for _, history := range histories {
+		if history.Date < system.StartDate || history.Date > system.EndDate {
+			continue
+		}
+
+		//	第一个交易日以收盘价全仓买入
+		if len(series.Dates) == 0 {
+			shares = math.Floor((system.StartAmount - system.Commission) / history.Close)
+			cash = system.StartAmount - system.Commission - shares*history.Close
+		}
+
+		series.Dates = append(series.Dates, history.Date)
+		series.Values = append(series.Values, cash+shares*history.Close)
+	}
+
+	benchmarkMutex.Lock()
+	benchmarkCache[key] = series
+	benchmarkMutex.Unlock()
+
+	return series, nil
+}
+
+//	等权买入持有所有股票的权益序列
+func getEqualWeight() (*Series, error) {
+
+	key := "EqualWeight"
+
+	benchmarkMutex.Lock()
+	series, found := benchmarkCache[key]
+	benchmarkMutex.Unlock()
+	if found {
+		return series, nil
+	}
+
+	system, err := currentSystem()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Series, 0, len(system.Codes))
+	for _, code := range system.Codes {
+		buyAndHold, err := getBuyAndHold(code)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, *buyAndHold)
+	}
+
+	//	每只股票投入相同的资金
+	series, err = sumSeries("EqualWeight", list, system.StartAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(list) > 0 {
+		scale := 1 / float64(len(list))
+		for index := range series.Values {
+			series.Values[index] *= scale
+		}
+	}
+
+	benchmarkMutex.Lock()
+	benchmarkCache[key] = series
+	benchmarkMutex.Unlock()
+
+	return series, nil
+}
+
+//	按交易所日历逐日合计多个权益序列，序列开始前按初始资金计算，缺失的日期沿用前一日的值
+func sumSeries(name string, list []Series, initial float64) (*Series, error) {
+
+	first, last := "", ""
+	for _, series := range list {
+		if len(series.Dates) == 0 {
+			continue
+		}
+
+		if first == "" || series.Dates[0] < first {
+			first = series.Dates[0]
+		}
+
+		if series.Dates[len(series.Dates)-1] > last {
+			last = series.Dates[len(series.Dates)-1]
+		}
+	}
+
+	if first == "" {
+		return &Series{Name: name, Dates: []string{}, Values: []float64{}}, nil
+	}
+
+	dates, err := calendar.TradingDates(first, last)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]float64, len(dates))
+	for _, series := range list {
+		position, value := 0, initial
+		for index, date := range dates {
+			for position < len(series.Dates) && series.Dates[position] <= date {
+				value = series.Values[position]
+				position++
+			}
+
+			values[index] += value
+		}
+	}
+
+	return &Series{Name: name, Dates: dates, Values: values}, nil
+}
+
+//	计算策略相对基准的各项指标
+func compare(strategy, benchmark Series) BenchmarkMetrics {
+
+	metrics := BenchmarkMetrics{Benchmark: benchmark.Name}
+
+	//	只比较双方都有数据的日期
+	benchmarkValues := make(map[string]float64)
+	for index, date := range benchmark.Dates {
+		benchmarkValues[date] = benchmark.Values[index]
+	}
+
+	dates := make([]string, 0, len(strategy.Dates))
+	strategyList := make([]float64, 0, len(strategy.Dates))
+	benchmarkList := make([]float64, 0, len(strategy.Dates))
+	for index, date := range strategy.Dates {
+		value, found := benchmarkValues[date]
+		if !found {
+			continue
+		}
+
+		dates = append(dates, date)
+		strategyList = append(strategyList, strategy.Values[index])
+		benchmarkList = append(benchmarkList, value)
+	}
+
+	if len(strategyList) < 2 {
+		return metrics
+	}
+
+	last := len(strategyList) - 1
+	metrics.Return = strategyList[last]/strategyList[0] - 1
+	metrics.BenchmarkReturn = benchmarkList[last]/benchmarkList[0] - 1
+	metrics.ExcessReturn = metrics.Return - metrics.BenchmarkReturn
+
+	strategyReturns := dailyReturns(strategyList)
+	benchmarkReturns := dailyReturns(benchmarkList)
+
+	strategyMean := mean(strategyReturns)
+	benchmarkMean := mean(benchmarkReturns)
+
+	var covariance, strategyVariance, benchmarkVariance, trackingVariance float64
+	excessReturns := make([]float64, len(strategyReturns))
+	for index := range strategyReturns {
+		ds := strategyReturns[index] - strategyMean
+		db := benchmarkReturns[index] - benchmarkMean
+
+		covariance += ds * db
+		strategyVariance += ds * ds
+		benchmarkVariance += db * db
+		excessReturns[index] = strategyReturns[index] - benchmarkReturns[index]
+	}
+
+	excessMean := mean(excessReturns)
+	for _, excess := range excessReturns {
+		trackingVariance += (excess - excessMean) * (excess - excessMean)
+	}
+
+	if benchmarkVariance > 0 {
+		metrics.Beta = covariance / benchmarkVariance
+	}
+
+	if strategyVariance > 0 && benchmarkVariance > 0 {
+		metrics.Correlation = covariance / math.Sqrt(strategyVariance*benchmarkVariance)
+	}
+
+	//	按照K线周期年化
+	periods := periodsPerYear(dates)
+	metrics.Alpha = (strategyMean - metrics.Beta*benchmarkMean) * periods
+	if len(excessReturns) > 1 {
+		metrics.TrackingError = math.Sqrt(trackingVariance/float64(len(excessReturns)-1)) * math.Sqrt(periods)
+	}
+
+	return metrics
+}
+
+//	根据日期推算每年的K线数量，日线约为252
+func periodsPerYear(dates []string) float64 {
+	if len(dates) < 2 {
+		return tradingDaysPerYear
+	}
+
+	first, err := calendar.ParseDate(dates[0])
+	if err != nil {
+		return tradingDaysPerYear
+	}
+
+	last, err := calendar.ParseDate(dates[len(dates)-1])
+	if err != nil || !last.After(first) {
+		return tradingDaysPerYear
+	}
+
+	years := last.Sub(first).Hours() / 24 / 365.25
+	return float64(len(dates)-1) / years
+}
+
+//	日收益率
+func dailyReturns(values []float64) []float64 {
+	returns := make([]float64, 0, len(values))
+	for index := 1; index < len(values); index++ {
+		if values[index-1] == 0 {
+			returns = append(returns, 0)
+			continue
+		}
+
+		returns = append(returns, values[index]/values[index-1]-1)
+	}
+
+	return returns
+}
+
+//	平均值
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+
+	return sum / float64(len(values))
+}
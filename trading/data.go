@@ -0,0 +1,163 @@
+package trading
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/history"
+	"github.com/nzai/Tast/indicator"
+	"github.com/nzai/Tast/intraday"
+	"github.com/nzai/Tast/peroidexterma"
+	"github.com/nzai/Tast/turtle"
+)
+
+//	测试所需的股票数据
+type stockData struct {
+	Estimator turtle.Estimator
+	Histories []history.DailyHistory
+	Turtles   map[int][]turtle.TurtleIndex
+	Extermas  map[int][]peroidexterma.PeroidExtermaIndex
+	Minutes   map[string][]float64 //	按日期保存的分钟K线价格路径
+}
+
+var (
+	stockDataCache = make(map[string]*stockData)
+	stockDataMutex sync.Mutex
+	indicatorCache = make(map[string]map[int][]indicator.Point)
+	indicatorMutex sync.Mutex
+)
+
+//	获取股票在指定K线周期下的历史与指标(带缓存)，N使用指定的方法估计波动性
+func getStockData(code string, timeframe history.Timeframe, estimator turtle.Estimator) (*stockData, error) {
+
+	stockDataMutex.Lock()
+	defer stockDataMutex.Unlock()
+
+	key := code + "." + string(timeframe) + "." + string(estimator)
+	data, found := stockDataCache[key]
+	if found {
+		return data, nil
+	}
+
+	//	数据保存目录
+	histories, err := history.GetStockBars(code, timeframe)
+	if err != nil {
+		return nil, err
+	}
+
+	turtles, err := turtle.GetStockIndex(code, timeframe, estimator)
+	if err != nil {
+		return nil, err
+	}
+
+	extermas, err := peroidexterma.GetStockIndex(code, timeframe)
+	if err != nil {
+		return nil, err
+	}
+
+	//	指标是按照历史逐日计算的，两者必须一一对应
+	for peroid, indexes := range turtles {
+		if len(indexes) != len(histories) {
+			return nil, fmt.Errorf("股票%s周期%d的海龟指标与历史记录数量不一致", code, peroid)
+		}
+	}
+
+	for peroid, indexes := range extermas {
+		if len(indexes) != len(histories) {
+			return nil, fmt.Errorf("股票%s周期%d的区间极值指标与历史记录数量不一致", code, peroid)
+		}
+	}
+
+	minutes, err := loadMinutePaths(code, timeframe, histories)
+	if err != nil {
+		return nil, err
+	}
+
+	data = &stockData{
+		Estimator: estimator,
+		Histories: histories,
+		Turtles:   turtles,
+		Extermas:  extermas,
+		Minutes:   minutes,
+	}
+	stockDataCache[key] = data
+
+	return data, nil
+}
+
+//	获取股票在指定K线周期下的技术指标(带缓存)，count为K线的数量
+func getIndicator(code string, timeframe history.Timeframe, ind indicator.Indicator, count int) (map[int][]indicator.Point, error) {
+
+	indicatorMutex.Lock()
+	defer indicatorMutex.Unlock()
+
+	key := code + "." + string(timeframe) + "." + ind.Name()
+	all, found := indicatorCache[key]
+	if found {
+		return all, nil
+	}
+
+	all, err := indicator.GetStockIndex(code, timeframe, ind)
+	if err != nil {
+		return nil, err
+	}
+
+	//	指标是按照历史逐日计算的，两者必须一一对应
+	for peroid, points := range all {
+		if len(points) != count {
+			return nil, fmt.Errorf("股票%s周期%d的%s指标与历史记录数量不一致", code, peroid, ind.Name())
+		}
+	}
+
+	indicatorCache[key] = all
+
+	return all, nil
+}
+
+//	读取与每日历史一致的分钟K线，转换为价格路径
+func loadMinutePaths(code string, timeframe history.Timeframe, histories []history.DailyHistory) (map[string][]float64, error) {
+
+	minutes := make(map[string][]float64)
+
+	//	分钟K线只用于日线
+	if timeframe != history.Daily || !config.GetBool(configTradingSection, configIntradayKey, true) {
+		return minutes, nil
+	}
+
+	provider, err := intraday.Default()
+	if err != nil {
+		return nil, err
+	}
+
+	dates, err := provider.Dates(code)
+	if err != nil || len(dates) == 0 {
+		return minutes, err
+	}
+
+	available := make(map[string]bool, len(dates))
+	for _, date := range dates {
+		available[date] = true
+	}
+
+	for _, h := range histories {
+		if !available[h.Date] {
+			continue
+		}
+
+		bars, err := provider.GetMinuteBars(code, h.Date)
+		if err != nil {
+			return nil, err
+		}
+
+		//	与每日历史不一致的分钟K线不使用
+		aggregated := intraday.Aggregate(bars)
+		if !intraday.Consistent(aggregated, h) {
+			continue
+		}
+
+		minutes[h.Date] = minutePath(bars, h.Close/aggregated.Close)
+	}
+
+	return minutes, nil
+}
@@ -0,0 +1,156 @@
+package trading
+
+import (
+	"math"
+
+	"github.com/nzai/Tast/metrics"
+)
+
+//	用指定策略测试一只股票，测试区间、资金、手续费、K线周期及撮合方式取自当前的交易系统配置
+func Backtest(code string, strategy Strategy) (*TradingResult, error) {
+
+	system, err := currentSystem()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := getStockData(code, system.Timeframe, system.Volatility)
+	if err != nil {
+		return nil, err
+	}
+
+	account := &Account{
+		Commission: system.Commission,
+		Cash:       system.StartAmount,
+		Positions:  make([]position, 0),
+		Trades:     make([]Trade, 0),
+	}
+
+	bars := &Bars{
+		Code:      code,
+		Timeframe: system.Timeframe,
+		Histories: data.Histories,
+		Account:   account,
+		system:    system,
+		data:      data,
+	}
+
+	err = strategy.Prepare(bars)
+	if err != nil {
+		return nil, err
+	}
+
+	equity := Series{Name: code, Dates: make([]string, 0), Values: make([]float64, 0)}
+	var lastClose float64
+
+	for index, history := range data.Histories {
+		if index == 0 || history.Date < system.StartDate || history.Date > system.EndDate {
+			continue
+		}
+
+		//	使用前一日收盘后的数据决定当日的交易
+		bars.Index = index
+		session := &session{
+			bars:     bars,
+			strategy: strategy,
+			date:     history.Date,
+			orders:   strategy.OnBar(bars),
+		}
+
+		//	沿当日的价格路径撮合
+		walk(data.pricePath(history, system.Fill, account.Holding()), session)
+
+		lastClose = history.Close
+		equity.Dates = append(equity.Dates, history.Date)
+		equity.Values = append(equity.Values, account.Equity(lastClose))
+	}
+
+	//	测试结束时按最后收盘价平仓
+	if account.Holding() {
+		account.sellAll(equity.Dates[len(equity.Dates)-1], lastClose)
+	}
+
+	result := &TradingResult{
+		Code:        code,
+		Strategy:    strategy.Name(),
+		StartAmount: system.StartAmount,
+		EndAmount:   account.Cash,
+		Trades:      account.Trades,
+		Equity:      equity,
+	}
+	result.Profit = result.EndAmount - result.StartAmount
+	result.ProfitPercent = result.Profit / result.StartAmount
+
+	metrics.BacktestFinished()
+
+	return result, nil
+}
+
+//	一个交易日内的撮合
+type session struct {
+	bars     *Bars
+	strategy Strategy
+	date     string
+	orders   []Order
+	blocked  bool //	资金不足时当日不再买入
+}
+
+//	当前的买入和卖出触发价，0表示没有
+//	以开盘价成交的委托视为开盘即被越过的触发价
+func (s *session) Levels() (float64, float64) {
+
+	var up, down float64
+	for _, order := range s.orders {
+		if order.Buy {
+			if s.blocked {
+				continue
+			}
+
+			price := order.Price
+			if price <= 0 {
+				price = math.SmallestNonzeroFloat64
+			}
+
+			if up == 0 || price < up {
+				up = price
+			}
+		} else if s.bars.Account.Holding() {
+			price := order.Price
+			if price <= 0 {
+				price = math.MaxFloat64
+			}
+
+			down = math.Max(down, price)
+		}
+	}
+
+	return up, down
+}
+
+//	触发价成交
+func (s *session) Fill(buy bool, price float64) {
+
+	account := s.bars.Account
+	if !buy {
+		shares := account.Shares
+		account.sellAll(s.date, price)
+		s.orders = s.strategy.OnFill(s.bars, Fill{Buy: false, Price: price, Shares: shares})
+		return
+	}
+
+	//	触发的是触发价最低的买入委托
+	var triggered Order
+	for _, order := range s.orders {
+		if order.Buy && (!triggered.Buy || order.Price < triggered.Price) {
+			triggered = order
+		}
+	}
+
+	shares := account.buy(s.date, price, triggered)
+	if shares <= 0 {
+		s.blocked = true
+		return
+	}
+
+	s.orders = s.strategy.OnFill(s.bars, Fill{Buy: true, Price: price, Shares: shares})
+}
@@ -0,0 +1,413 @@
+package trading
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//	规则中的表达式
+//	支持数字、价格变量(open、high、low、close、volume)、入市价entry、指标函数调用，
+//	四则运算、比较运算以及and、or、not(也可以写作&&、||、!)
+//	比较和逻辑运算的结果为1或0，任何一方为NaN时比较结果为0
+type expression interface {
+	eval(index int, env *ruleEnv) float64
+}
+
+//	数字
+type numberExpression float64
+
+func (e numberExpression) eval(index int, env *ruleEnv) float64 {
+	return float64(e)
+}
+
+//	变量
+type variableExpression string
+
+func (e variableExpression) eval(index int, env *ruleEnv) float64 {
+	if e == "entry" {
+		return env.entry
+	}
+
+	h := env.histories[index]
+	switch e {
+	case "open":
+		return h.Open
+	case "high":
+		return h.High
+	case "low":
+		return h.Low
+	case "close":
+		return h.Close
+	default:
+		return float64(h.Volume)
+	}
+}
+
+//	指标函数调用，参数只能是数字
+type callExpression struct {
+	Name string
+	Args []int
+}
+
+//	函数调用的唯一标识
+func (e *callExpression) key() string {
+	args := make([]string, 0, len(e.Args))
+	for _, arg := range e.Args {
+		args = append(args, strconv.Itoa(arg))
+	}
+
+	return fmt.Sprintf("%s(%s)", e.Name, strings.Join(args, ","))
+}
+
+func (e *callExpression) eval(index int, env *ruleEnv) float64 {
+	return env.series[e.key()][index]
+}
+
+//	一元运算
+type unaryExpression struct {
+	Op      string
+	Operand expression
+}
+
+func (e *unaryExpression) eval(index int, env *ruleEnv) float64 {
+	value := e.Operand.eval(index, env)
+	if e.Op == "-" {
+		return -value
+	}
+
+	//	not
+	return boolValue(!truth(value))
+}
+
+//	二元运算
+type binaryExpression struct {
+	Op          string
+	Left, Right expression
+}
+
+func (e *binaryExpression) eval(index int, env *ruleEnv) float64 {
+
+	left := e.Left.eval(index, env)
+
+	//	逻辑运算短路求值
+	switch e.Op {
+	case "and":
+		if !truth(left) {
+			return 0
+		}
+		return boolValue(truth(e.Right.eval(index, env)))
+	case "or":
+		if truth(left) {
+			return 1
+		}
+		return boolValue(truth(e.Right.eval(index, env)))
+	}
+
+	right := e.Right.eval(index, env)
+	switch e.Op {
+	case "+":
+		return left + right
+	case "-":
+		return left - right
+	case "*":
+		return left * right
+	case "/":
+		if right == 0 {
+			return math.NaN()
+		}
+		return left / right
+	case ">":
+		return boolValue(left > right)
+	case "<":
+		return boolValue(left < right)
+	case ">=":
+		return boolValue(left >= right)
+	case "<=":
+		return boolValue(left <= right)
+	case "==":
+		return boolValue(left == right)
+	default:
+		return boolValue(left != right)
+	}
+}
+
+//	数值是否为真，NaN为假
+func truth(value float64) bool {
+	return value != 0 && !math.IsNaN(value)
+}
+
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+
+	return 0
+}
+
+//	遍历表达式中的所有函数调用
+func walkCalls(e expression, visit func(*callExpression)) {
+	switch node := e.(type) {
+	case *callExpression:
+		visit(node)
+	case *unaryExpression:
+		walkCalls(node.Operand, visit)
+	case *binaryExpression:
+		walkCalls(node.Left, visit)
+		walkCalls(node.Right, visit)
+	}
+}
+
+//	解析表达式
+func parseExpression(text string) (expression, error) {
+
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &expressionParser{text: text, tokens: tokens}
+	e, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if parser.position < len(parser.tokens) {
+		return nil, fmt.Errorf("表达式%s在%s处有多余的内容", text, parser.tokens[parser.position])
+	}
+
+	return e, nil
+}
+
+//	将表达式拆分为单词，逻辑运算统一为and、or、not
+func tokenize(text string) ([]string, error) {
+
+	runes := []rune(text)
+	tokens := make([]string, 0)
+	for index := 0; index < len(runes); {
+		r := runes[index]
+		switch {
+		case unicode.IsSpace(r):
+			index++
+		case unicode.IsDigit(r) || r == '.':
+			start := index
+			for index < len(runes) && (unicode.IsDigit(runes[index]) || runes[index] == '.') {
+				index++
+			}
+			tokens = append(tokens, string(runes[start:index]))
+		case unicode.IsLetter(r) || r == '_':
+			start := index
+			for index < len(runes) && (unicode.IsLetter(runes[index]) || unicode.IsDigit(runes[index]) || runes[index] == '_') {
+				index++
+			}
+			tokens = append(tokens, strings.ToLower(string(runes[start:index])))
+		default:
+			//	两个字符的运算符
+			if index+1 < len(runes) {
+				switch op := string(runes[index : index+2]); op {
+				case ">=", "<=", "==", "!=":
+					tokens = append(tokens, op)
+					index += 2
+					continue
+				case "&&":
+					tokens = append(tokens, "and")
+					index += 2
+					continue
+				case "||":
+					tokens = append(tokens, "or")
+					index += 2
+					continue
+				}
+			}
+
+			switch r {
+			case '+', '-', '*', '/', '>', '<', '(', ')', ',':
+				tokens = append(tokens, string(r))
+			case '!':
+				tokens = append(tokens, "not")
+			default:
+				return nil, fmt.Errorf("表达式%s中有不支持的字符%c", text, r)
+			}
+			index++
+		}
+	}
+
+	return tokens, nil
+}
+
+//	递归下降解析器，优先级从低到高依次为 or、and、not、比较、加减、乘除、负号
+type expressionParser struct {
+	text     string
+	tokens   []string
+	position int
+}
+
+//	当前单词，结束时为空
+func (parser *expressionParser) peek() string {
+	if parser.position < len(parser.tokens) {
+		return parser.tokens[parser.position]
+	}
+
+	return ""
+}
+
+func (parser *expressionParser) next() string {
+	token := parser.peek()
+	parser.position++
+	return token
+}
+
+func (parser *expressionParser) expect(token string) error {
+	if actual := parser.next(); actual != token {
+		return fmt.Errorf("表达式%s中应为%s，实际为%s", parser.text, token, actual)
+	}
+
+	return nil
+}
+
+func (parser *expressionParser) parseOr() (expression, error) {
+	return parser.parseBinary([]string{"or"}, parser.parseAnd)
+}
+
+func (parser *expressionParser) parseAnd() (expression, error) {
+	return parser.parseBinary([]string{"and"}, parser.parseNot)
+}
+
+func (parser *expressionParser) parseNot() (expression, error) {
+	if parser.peek() == "not" {
+		parser.next()
+		operand, err := parser.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		return &unaryExpression{Op: "not", Operand: operand}, nil
+	}
+
+	return parser.parseComparison()
+}
+
+func (parser *expressionParser) parseComparison() (expression, error) {
+	return parser.parseBinary([]string{">", "<", ">=", "<=", "==", "!="}, parser.parseAdditive)
+}
+
+func (parser *expressionParser) parseAdditive() (expression, error) {
+	return parser.parseBinary([]string{"+", "-"}, parser.parseMultiplicative)
+}
+
+func (parser *expressionParser) parseMultiplicative() (expression, error) {
+	return parser.parseBinary([]string{"*", "/"}, parser.parseUnary)
+}
+
+//	左结合的二元运算
+func (parser *expressionParser) parseBinary(ops []string, operand func() (expression, error)) (expression, error) {
+
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := parser.peek()
+		found := false
+		for _, candidate := range ops {
+			if op == candidate {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return left, nil
+		}
+
+		parser.next()
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &binaryExpression{Op: op, Left: left, Right: right}
+	}
+}
+
+func (parser *expressionParser) parseUnary() (expression, error) {
+	if parser.peek() == "-" {
+		parser.next()
+		operand, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return &unaryExpression{Op: "-", Operand: operand}, nil
+	}
+
+	return parser.parsePrimary()
+}
+
+func (parser *expressionParser) parsePrimary() (expression, error) {
+
+	token := parser.next()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("表达式%s不完整", parser.text)
+	case token == "(":
+		e, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		return e, parser.expect(")")
+	case unicode.IsDigit(rune(token[0])) || token[0] == '.':
+		value, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("表达式%s中的数字%s不正确", parser.text, token)
+		}
+
+		return numberExpression(value), nil
+	case unicode.IsLetter(rune(token[0])) || token[0] == '_':
+		if parser.peek() != "(" {
+			switch token {
+			case "open", "high", "low", "close", "volume", "entry":
+				return variableExpression(token), nil
+			}
+
+			return nil, fmt.Errorf("表达式%s中有未知的变量%s", parser.text, token)
+		}
+
+		return parser.parseCall(token)
+	}
+
+	return nil, fmt.Errorf("表达式%s中%s的位置不正确", parser.text, token)
+}
+
+//	函数调用，参数为整数
+func (parser *expressionParser) parseCall(name string) (expression, error) {
+
+	parser.next()
+	call := &callExpression{Name: name, Args: make([]int, 0)}
+	if parser.peek() == ")" {
+		parser.next()
+		return call, nil
+	}
+
+	for {
+		token := parser.next()
+		value, err := strconv.Atoi(token)
+		if err != nil {
+			return nil, fmt.Errorf("表达式%s中函数%s的参数%s必须是整数", parser.text, name, token)
+		}
+		call.Args = append(call.Args, value)
+
+		switch parser.next() {
+		case ",":
+			continue
+		case ")":
+			return call, nil
+		default:
+			return nil, fmt.Errorf("表达式%s中函数%s的参数列表不正确", parser.text, name)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package trading
+
+import (
+	"math"
+
+	"github.com/nzai/Tast/history"
+	"github.com/nzai/Tast/intraday"
+)
+
+const (
+	configTradingSection = "trading"
+	configFillKey        = "fill"
+	configIntradayKey    = "intraday"
+	configVolatilityKey  = "volatility"
+	configCodesKey       = "codes"
+	configUnitRiskKey    = "unitrisk"
+	configStopUnitKey    = "stopunit"
+	configPyramidStepKey = "pyramidstep"
+)
+
+//	没有分钟K线时推断日内价格路径的方式
+const (
+	FillPessimistic  = "pessimistic"  //	先走不利的方向：持仓时先到最低价，空仓时先到最高价
+	FillOptimistic   = "optimistic"   //	先走有利的方向
+	FillOpenDistance = "opendistance" //	先到离开盘价较近的极值
+)
+
+//	沿价格路径撮合的交易者
+type pathTrader interface {
+	//	当前的买入和卖出触发价，0表示没有
+	Levels() (float64, float64)
+	//	触发价成交
+	Fill(buy bool, price float64)
+}
+
+//	沿价格路径依次检查触发价，价格在相邻两点之间线性移动
+//	开盘即越过触发价时以开盘价成交
+func walk(path []float64, trader pathTrader) {
+
+	if len(path) == 0 {
+		return
+	}
+
+	//	开盘
+	price := path[0]
+	settle(price, trader)
+
+	for _, next := range path[1:] {
+		for price != next {
+			up, down := trader.Levels()
+			if next > price && up > 0 && up <= next {
+				//	上涨途中触及买入价
+				price = math.Max(price, up)
+				trader.Fill(true, price)
+				settle(price, trader)
+				continue
+			}
+
+			if next < price && down > 0 && down >= next {
+				//	下跌途中触及卖出价
+				price = math.Min(price, down)
+				trader.Fill(false, price)
+				settle(price, trader)
+				continue
+			}
+
+			price = next
+		}
+	}
+}
+
+//	当前价格已经越过的触发价立即成交
+func settle(price float64, trader pathTrader) {
+	for {
+		up, down := trader.Levels()
+		switch {
+		case down > 0 && price <= down:
+			trader.Fill(false, price)
+		case up > 0 && price >= up:
+			trader.Fill(true, price)
+		default:
+			return
+		}
+	}
+}
+
+//	当日的价格路径，有可用的分钟K线时使用分钟K线
+func (data *stockData) pricePath(bar history.DailyHistory, fill string, holding bool) []float64 {
+
+	path, found := data.Minutes[bar.Date]
+	if found {
+		return path
+	}
+
+	return dailyPath(bar, fill, holding)
+}
+
+//	根据日线推断的价格路径
+func dailyPath(bar history.DailyHistory, fill string, holding bool) []float64 {
+
+	lowFirst := false
+	switch fill {
+	case FillOptimistic:
+		lowFirst = !holding
+	case FillOpenDistance:
+		lowFirst = bar.Open-bar.Low < bar.High-bar.Open
+	default:
+		lowFirst = holding
+	}
+
+	if lowFirst {
+		return []float64{bar.Open, bar.Low, bar.High, bar.Close}
+	}
+
+	return []float64{bar.Open, bar.High, bar.Low, bar.Close}
+}
+
+//	由分钟K线组成的价格路径，每分钟内先到离开盘价较近的极值
+//	分钟K线是未复权的价格，按factor换算为与每日历史一致的复权价格
+func minutePath(bars []intraday.MinuteBar, factor float64) []float64 {
+
+	path := make([]float64, 0, len(bars)*4)
+	for _, bar := range bars {
+		path = append(path, bar.Open*factor)
+		if bar.Open-bar.Low < bar.High-bar.Open {
+			path = append(path, bar.Low*factor, bar.High*factor)
+		} else {
+			path = append(path, bar.High*factor, bar.Low*factor)
+		}
+		path = append(path, bar.Close*factor)
+	}
+
+	return path
+}
@@ -0,0 +1,303 @@
+package trading
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/history"
+	"github.com/nzai/Tast/indicator"
+)
+
+const (
+	configFilterSection     = "filter"
+	configTrendKey          = "trend"
+	configADXKey            = "adx"
+	configADXPeroidKey      = "adxperoid"
+	configIndexTrendKey     = "indextrend"
+	configVolatilityMinKey  = "volatilitymin"
+	configVolatilityMaxKey  = "volatilitymax"
+	configLookbackKey       = "volatilitylookback"
+	defaultADXPeroid        = 14
+	defaultVolatilityWindow = 252
+)
+
+var (
+	filterCache = make(map[string][]bool)
+	filterMutex sync.Mutex
+)
+
+//	从配置文件读取入市过滤条件的遍历范围
+//	每个条件可以是单个值，也可以是 开始-结束 或 开始-结束:步长 的范围，作为参数遍历的维度
+func loadFilters(system *TurtleTradingSystem) error {
+
+	for _, item := range []struct {
+		key   string
+		field func(*TurtleTradingSystemParameter) *int
+	}{
+		{configTrendKey, func(p *TurtleTradingSystemParameter) *int { return &p.Trend }},
+		{configADXKey, func(p *TurtleTradingSystemParameter) *int { return &p.ADX }},
+		{configIndexTrendKey, func(p *TurtleTradingSystemParameter) *int { return &p.IndexTrend }},
+		{configVolatilityMinKey, func(p *TurtleTradingSystemParameter) *int { return &p.VolatilityMin }},
+		{configVolatilityMaxKey, func(p *TurtleTradingSystemParameter) *int { return &p.VolatilityMax }},
+	} {
+		start, end, step, err := parseRange(config.GetString(configFilterSection, item.key, "0"))
+		if err != nil {
+			return fmt.Errorf("入市过滤条件%s不正确:%v", item.key, err)
+		}
+
+		*item.field(&system.Start) = start
+		*item.field(&system.End) = end
+		*item.field(&system.Step) = step
+		*item.field(&system.Current) = start
+		*item.field(&system.Best) = start
+	}
+
+	return nil
+}
+
+//	解析 开始-结束:步长 格式的范围
+func parseRange(value string) (int, int, int, error) {
+
+	value = strings.TrimSpace(value)
+	step := 1
+	if position := strings.Index(value, ":"); position >= 0 {
+		var err error
+		step, err = strconv.Atoi(strings.TrimSpace(value[position+1:]))
+		if err != nil || step <= 0 {
+			return 0, 0, 0, fmt.Errorf("步长%s不正确", value[position+1:])
+		}
+		value = value[:position]
+	}
+
+	parts := strings.SplitN(value, "-", 2)
+	start, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	end := start
+	if len(parts) == 2 {
+		end, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
+	if start < 0 || end < start {
+		return 0, 0, 0, fmt.Errorf("范围%s不正确", value)
+	}
+
+	return start, end, step, nil
+}
+
+//	根据参数计算每根K线收盘后是否允许入市，没有过滤条件时返回nil
+func entryFilter(bars *Bars, parameter TurtleTradingSystemParameter) ([]bool, error) {
+
+	filters := make([][]bool, 0)
+
+	if parameter.Trend > 0 {
+		filter, err := cachedFilter(fmt.Sprintf("%s.%s.Trend.%d", bars.Code, bars.Timeframe, parameter.Trend), func() ([]bool, error) {
+			return trendFilter(bars.Histories, bars.Histories, parameter.Trend), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+
+	if parameter.ADX > 0 {
+		filter, err := cachedFilter(fmt.Sprintf("%s.%s.ADX.%d", bars.Code, bars.Timeframe, parameter.ADX), func() ([]bool, error) {
+			return adxFilter(bars, parameter.ADX)
+		})
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+
+	if parameter.IndexTrend > 0 {
+		filter, err := cachedFilter(fmt.Sprintf("%s.%s.IndexTrend.%d", bars.Code, bars.Timeframe, parameter.IndexTrend), func() ([]bool, error) {
+			return indexTrendFilter(bars, parameter.IndexTrend)
+		})
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+
+	if parameter.VolatilityMin > 0 || parameter.VolatilityMax > 0 {
+		filter, err := cachedFilter(fmt.Sprintf("%s.%s.%s.Volatility.%d.%d.%d", bars.Code, bars.Timeframe, bars.data.Estimator, parameter.N, parameter.VolatilityMin, parameter.VolatilityMax), func() ([]bool, error) {
+			return volatilityFilter(bars, parameter.N, parameter.VolatilityMin, parameter.VolatilityMax)
+		})
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+
+	if len(filters) == 0 {
+		return nil, nil
+	}
+
+	//	所有条件都满足时才允许入市
+	allowed := make([]bool, len(bars.Histories))
+	for index := range allowed {
+		allowed[index] = true
+		for _, filter := range filters {
+			allowed[index] = allowed[index] && filter[index]
+		}
+	}
+
+	return allowed, nil
+}
+
+//	带缓存的过滤条件
+func cachedFilter(key string, calculate func() ([]bool, error)) ([]bool, error) {
+
+	filterMutex.Lock()
+	filter, found := filterCache[key]
+	filterMutex.Unlock()
+	if found {
+		return filter, nil
+	}
+
+	filter, err := calculate()
+	if err != nil {
+		return nil, err
+	}
+
+	filterMutex.Lock()
+	filterCache[key] = filter
+	filterMutex.Unlock()
+
+	return filter, nil
+}
+
+//	收盘价高于peroid周期均线，trend为计算均线的K线，按日期与bars对齐
+func trendFilter(bars, trend []history.DailyHistory, peroid int) []bool {
+
+	//	均线及收盘价是否在其上方
+	above := make(map[string]bool, len(trend))
+	var sum float64
+	for index, h := range trend {
+		sum += h.Close
+		if index >= peroid {
+			sum -= trend[index-peroid].Close
+		}
+
+		if index >= peroid-1 {
+			above[h.Date] = h.Close > sum/float64(peroid)
+		}
+	}
+
+	//	使用不晚于当日的最近一根K线
+	filter := make([]bool, len(bars))
+	position, current := 0, false
+	for index, h := range bars {
+		for position < len(trend) && trend[position].Date <= h.Date {
+			current = above[trend[position].Date]
+			position++
+		}
+
+		filter[index] = current
+	}
+
+	return filter
+}
+
+//	ADX高于threshold
+func adxFilter(bars *Bars, threshold int) ([]bool, error) {
+
+	adx, err := indicator.Get("ADX")
+	if err != nil {
+		return nil, err
+	}
+
+	points, err := bars.Indicator(adx, config.GetInt(configFilterSection, configADXPeroidKey, defaultADXPeroid))
+	if err != nil {
+		return nil, err
+	}
+
+	filter := make([]bool, len(points))
+	for index, point := range points {
+		filter[index] = point.Valid && point.Values[0] > float64(threshold)
+	}
+
+	return filter, nil
+}
+
+//	指数收盘价高于peroid周期均线
+func indexTrendFilter(bars *Bars, peroid int) ([]bool, error) {
+
+	indexCode := config.GetString(configBenchmarkSection, configBenchmarkIndex, "")
+	if indexCode == "" {
+		return nil, fmt.Errorf("使用指数趋势过滤时必须配置指数")
+	}
+
+	index, err := history.GetStockBars(indexCode, bars.Timeframe)
+	if err != nil {
+		return nil, err
+	}
+
+	return trendFilter(bars.Histories, index, peroid), nil
+}
+
+//	N占收盘价的比例在过去一段时间中的百分位位于[min, max]之间，max为0时没有上限
+func volatilityFilter(bars *Bars, peroid, min, max int) ([]bool, error) {
+
+	turtles, found := bars.data.Turtles[peroid]
+	if !found {
+		return nil, fmt.Errorf("股票%s缺少周期为%d的海龟指标", bars.Code, peroid)
+	}
+
+	if max <= 0 {
+		max = 100
+	}
+
+	window := config.GetInt(configFilterSection, configLookbackKey, defaultVolatilityWindow)
+	if window < 2 {
+		window = 2
+	}
+
+	ratios := make([]float64, len(turtles))
+	filter := make([]bool, len(turtles))
+	for index, t := range turtles {
+		ratios[index] = math.NaN()
+		if t.Valid && bars.Histories[index].Close > 0 {
+			ratios[index] = t.N / bars.Histories[index].Close
+		}
+
+		if math.IsNaN(ratios[index]) {
+			continue
+		}
+
+		start := index - window + 1
+		if start < 0 {
+			start = 0
+		}
+
+		samples := make([]float64, 0, index-start+1)
+		for _, ratio := range ratios[start : index+1] {
+			if !math.IsNaN(ratio) {
+				samples = append(samples, ratio)
+			}
+		}
+
+		//	样本不足一个窗口时不入市
+		if len(samples) < window {
+			continue
+		}
+
+		sort.Float64s(samples)
+		rank := sort.SearchFloat64s(samples, ratios[index])
+		percentile := float64(rank) * 100 / float64(len(samples)-1)
+		filter[index] = percentile >= float64(min) && percentile <= float64(max)
+	}
+
+	return filter, nil
+}
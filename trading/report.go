@@ -0,0 +1,173 @@
+package trading
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/nzai/Tast/atomicfile"
+	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/logging"
+)
+
+const (
+	configStrategySection = "strategy"
+	configStrategiesKey   = "strategies"
+	reportFileName        = "Strategy.txt"
+)
+
+//	测试报告的文件名，位于数据目录下
+func ReportFiles() []string {
+	return []string{reportFileName, dataFileName}
+}
+
+//	策略在所有股票上的汇总结果
+type StrategyReport struct {
+	Strategy      string
+	Codes         int
+	StartAmount   float64
+	Profit        float64
+	ProfitPercent float64
+	Trades        int
+	WinRate       float64 //	盈利交易占全部交易的比例
+	Benchmarks    []BenchmarkMetrics
+}
+
+//	汇总策略在所有股票上的测试结果并与基准比较
+func NewStrategyReport(strategy string, results []*TradingResult) (*StrategyReport, error) {
+
+	report := &StrategyReport{Strategy: strategy, Codes: len(results)}
+	wins := 0
+	for _, result := range results {
+		report.StartAmount += result.StartAmount
+		report.Profit += result.Profit
+		report.Trades += len(result.Trades)
+		for _, trade := range result.Trades {
+			if trade.Profit > 0 {
+				wins++
+			}
+		}
+	}
+
+	if report.StartAmount > 0 {
+		report.ProfitPercent = report.Profit / report.StartAmount
+	}
+
+	if report.Trades > 0 {
+		report.WinRate = float64(wins) / float64(report.Trades)
+	}
+
+	benchmarks, err := compareAggregate(results)
+	if err != nil {
+		return nil, err
+	}
+	report.Benchmarks = benchmarks
+
+	return report, nil
+}
+
+func (report StrategyReport) String() string {
+
+	lines := []string{
+		fmt.Sprintf("Strategy = %s", report.Strategy),
+		fmt.Sprintf("Codes = %d", report.Codes),
+		fmt.Sprintf("Profit = %.3f", report.Profit),
+		fmt.Sprintf("Profit = %.3f%%", report.ProfitPercent*100),
+		fmt.Sprintf("Trades = %d", report.Trades),
+		fmt.Sprintf("WinRate = %.3f%%", report.WinRate*100),
+	}
+
+	for _, benchmark := range report.Benchmarks {
+		lines = append(lines, fmt.Sprintf("Benchmark\t[%s]", benchmark))
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+//	用指定策略测试所有股票
+func TestStrategy(ctx context.Context, spec string) (*StrategyReport, error) {
+
+	system, err := currentSystem()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*TradingResult, 0, len(system.Codes))
+	name := spec
+	for _, code := range system.Codes {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		//	策略带有状态，每只股票使用新的实例
+		strategy, err := ParseStrategy(spec)
+		if err != nil {
+			return nil, err
+		}
+		name = strategy.Name()
+
+		result, err := Backtest(code, strategy)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, result)
+	}
+
+	return NewStrategyReport(name, results)
+}
+
+//	测试配置文件中指定的所有策略并保存报告
+func TestStrategies(ctx context.Context) error {
+
+	specs := make([]string, 0)
+	for _, spec := range strings.Split(config.GetString(configStrategySection, configStrategiesKey, ""), ",") {
+		if strings.TrimSpace(spec) != "" {
+			specs = append(specs, strings.TrimSpace(spec))
+		}
+	}
+
+	if len(specs) == 0 {
+		return nil
+	}
+
+	logger := logging.Stage("strategies")
+	logger.Info("开始测试交易策略", "strategies", len(specs))
+
+	reports := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		report, err := TestStrategy(ctx, spec)
+		if err != nil {
+			return err
+		}
+
+		reports = append(reports, report.String())
+	}
+
+	dataDir, err := config.GetDataDir()
+	if err != nil {
+		return err
+	}
+
+	//	打开文件
+	file, err := atomicfile.Create(filepath.Join(dataDir, reportFileName))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(strings.Join(reports, "\n"))
+	if err != nil {
+		return err
+	}
+
+	err = file.Commit()
+	if err != nil {
+		return err
+	}
+
+	logger.Info("交易策略测试结束")
+
+	return nil
+}
@@ -0,0 +1,281 @@
+package trading
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/nzai/Tast/history"
+	"github.com/nzai/Tast/indicator"
+)
+
+//	规则文件的内容，例如
+//	name: breakout
+//	entry: close > channel_max(20)
+//	exit: close < channel_min(10)
+//	stop: entry - 2 * n(20)
+//	risk: 0.01
+//	volatility: n(20)
+//
+//	条件在K线收盘后判断，满足时次日开盘成交；价格变量取该K线的数值，指标函数取前一根K线收盘后的数值
+//	指标函数有n(周期)、channel_max(周期)、channel_min(周期)以及所有已注册的技术指标，
+//	技术指标默认取第一列，其他列写作 指标_列名，例如bollinger_upper(20)、macd_signal()
+type ruleDefinition struct {
+	Name       string  `yaml:"name"`
+	Entry      string  `yaml:"entry"`      //	入市条件
+	Exit       string  `yaml:"exit"`       //	退出条件
+	Stop       string  `yaml:"stop"`       //	止损价，可以使用入市价entry
+	Risk       float64 `yaml:"risk"`       //	每次买入承担的波动占账户权益的比例，0表示全仓买入
+	Volatility string  `yaml:"volatility"` //	每股承担的波动
+}
+
+//	由规则文件编译的策略
+type ruleStrategy struct {
+	Definition ruleDefinition
+	entry      expression
+	exit       expression
+	stop       expression
+	volatility expression
+	env        *ruleEnv
+	stopPrice  float64
+}
+
+//	表达式求值的环境
+type ruleEnv struct {
+	histories []history.DailyHistory
+	series    map[string][]float64 //	函数调用对应的序列，已经后移一根K线
+	entry     float64
+}
+
+//	读取规则文件并编译为策略
+func LoadRule(filePath string) (Strategy, error) {
+
+	buffer, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseRule(buffer)
+}
+
+//	解析规则并编译为策略
+func ParseRule(buffer []byte) (Strategy, error) {
+
+	var definition ruleDefinition
+	err := yaml.Unmarshal(buffer, &definition)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(definition.Entry) == "" {
+		return nil, fmt.Errorf("规则%s没有入市条件", definition.Name)
+	}
+
+	if definition.Risk > 0 && strings.TrimSpace(definition.Volatility) == "" {
+		return nil, fmt.Errorf("规则%s指定了risk但没有指定volatility", definition.Name)
+	}
+
+	strategy := &ruleStrategy{Definition: definition}
+	for _, item := range []struct {
+		text   string
+		target *expression
+	}{
+		{definition.Entry, &strategy.entry},
+		{definition.Exit, &strategy.exit},
+		{definition.Stop, &strategy.stop},
+		{definition.Volatility, &strategy.volatility},
+	} {
+		if strings.TrimSpace(item.text) == "" {
+			continue
+		}
+
+		*item.target, err = parseExpression(item.text)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return strategy, nil
+}
+
+func (strategy *ruleStrategy) Name() string {
+	return fmt.Sprintf("Rule[%s]", strategy.Definition.Name)
+}
+
+//	载入规则中用到的所有指标
+func (strategy *ruleStrategy) Prepare(bars *Bars) error {
+
+	env := &ruleEnv{
+		histories: bars.Histories,
+		series:    make(map[string][]float64),
+		entry:     math.NaN(),
+	}
+
+	var err error
+	for _, e := range []expression{strategy.entry, strategy.exit, strategy.stop, strategy.volatility} {
+		if e == nil {
+			continue
+		}
+
+		walkCalls(e, func(call *callExpression) {
+			if err != nil {
+				return
+			}
+
+			key := call.key()
+			if _, found := env.series[key]; found {
+				return
+			}
+
+			var values []float64
+			values, err = callSeries(bars, call)
+			if err != nil {
+				return
+			}
+
+			//	指标取前一根K线收盘后的数值
+			env.series[key] = append([]float64{math.NaN()}, values...)[:len(values)]
+		})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	strategy.env = env
+	strategy.stopPrice = 0
+
+	return nil
+}
+
+//	在前一根K线收盘后判断条件，次日开盘成交
+func (strategy *ruleStrategy) OnBar(bars *Bars) []Order {
+
+	prev := bars.Index - 1
+	if bars.Account.Holding() {
+		if strategy.exit != nil && truth(strategy.exit.eval(prev, strategy.env)) {
+			return []Order{{Buy: false}}
+		}
+
+		if strategy.stopPrice > 0 {
+			return []Order{{Price: strategy.stopPrice}}
+		}
+
+		return nil
+	}
+
+	if !truth(strategy.entry.eval(prev, strategy.env)) {
+		return nil
+	}
+
+	order := Order{Buy: true}
+	if strategy.Definition.Risk > 0 {
+		volatility := strategy.volatility.eval(prev, strategy.env)
+		if !(volatility > 0) {
+			//	波动无效时不入市
+			return nil
+		}
+
+		order.Risk, order.RiskPerShare = strategy.Definition.Risk, volatility
+	}
+
+	return []Order{order}
+}
+
+func (strategy *ruleStrategy) OnFill(bars *Bars, fill Fill) []Order {
+
+	if !fill.Buy {
+		strategy.env.entry = math.NaN()
+		strategy.stopPrice = 0
+		return nil
+	}
+
+	//	入市后计算止损价，当日即生效
+	strategy.env.entry = fill.Price
+	if strategy.stop != nil {
+		stop := strategy.stop.eval(bars.Index-1, strategy.env)
+		if stop > 0 {
+			strategy.stopPrice = stop
+			return []Order{{Price: stop}}
+		}
+	}
+
+	return nil
+}
+
+//	函数调用对应的指标序列，与K线一一对应，无效的数值为NaN
+func callSeries(bars *Bars, call *callExpression) ([]float64, error) {
+
+	peroid := 0
+	switch len(call.Args) {
+	case 0:
+	case 1:
+		peroid = call.Args[0]
+	default:
+		return nil, fmt.Errorf("函数%s只能有一个参数", call.key())
+	}
+
+	values := make([]float64, len(bars.Histories))
+	switch call.Name {
+	case "n":
+		turtles, found := bars.data.Turtles[peroid]
+		if !found {
+			return nil, fmt.Errorf("股票%s缺少周期为%d的海龟指标", bars.Code, peroid)
+		}
+
+		for index, t := range turtles {
+			values[index] = t.N
+		}
+	case "channel_max", "channel_min":
+		extermas, found := bars.data.Extermas[peroid]
+		if !found {
+			return nil, fmt.Errorf("股票%s缺少周期为%d的区间极值指标", bars.Code, peroid)
+		}
+
+		for index, e := range extermas {
+			if call.Name == "channel_max" {
+				values[index] = e.Max
+			} else {
+				values[index] = e.Min
+			}
+		}
+	default:
+		//	技术指标，名称后可以跟列名
+		name, column := call.Name, 0
+		if position := strings.Index(name, "_"); position > 0 {
+			name = call.Name[:position]
+			column = -1
+		}
+
+		ind, err := indicator.Get(name)
+		if err != nil {
+			return nil, fmt.Errorf("不支持的函数%s", call.key())
+		}
+
+		if column < 0 {
+			for index, c := range ind.Columns() {
+				if name+"_"+c == call.Name {
+					column = index
+				}
+			}
+
+			if column < 0 {
+				return nil, fmt.Errorf("指标%s没有函数%s要求的列", ind.Name(), call.key())
+			}
+		}
+
+		points, err := bars.Indicator(ind, peroid)
+		if err != nil {
+			return nil, err
+		}
+
+		for index, point := range points {
+			values[index] = point.Values[column]
+		}
+	}
+
+	return values, nil
+}
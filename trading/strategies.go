@@ -0,0 +1,143 @@
+package trading
+
+import (
+	"fmt"
+
+	"github.com/nzai/Tast/indicator"
+)
+
+//	均线交叉策略：快线上穿慢线后次日开盘买入，快线跌破慢线后次日开盘卖出
+type crossoverStrategy struct {
+	Average indicator.Indicator
+	Fast    int
+	Slow    int
+	fast    []indicator.Point
+	slow    []indicator.Point
+}
+
+func newCrossoverStrategy(average indicator.Indicator, fast, slow int) (*crossoverStrategy, error) {
+	if fast <= 0 || fast >= slow {
+		return nil, fmt.Errorf("均线交叉策略的参数不正确 fast=%d slow=%d", fast, slow)
+	}
+
+	//	只能使用已经计算的周期
+	for _, peroid := range []int{fast, slow} {
+		err := indicator.CheckPeroid(average, peroid)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &crossoverStrategy{Average: average, Fast: fast, Slow: slow}, nil
+}
+
+func (strategy *crossoverStrategy) Name() string {
+	return fmt.Sprintf("MACross[%s Fast = %d Slow = %d]", strategy.Average.Name(), strategy.Fast, strategy.Slow)
+}
+
+func (strategy *crossoverStrategy) Prepare(bars *Bars) error {
+
+	fast, err := bars.Indicator(strategy.Average, strategy.Fast)
+	if err != nil {
+		return err
+	}
+
+	slow, err := bars.Indicator(strategy.Average, strategy.Slow)
+	if err != nil {
+		return err
+	}
+
+	strategy.fast, strategy.slow = fast, slow
+
+	return nil
+}
+
+func (strategy *crossoverStrategy) OnBar(bars *Bars) []Order {
+
+	prev := bars.Index - 1
+	if prev < 1 || !strategy.slow[prev-1].Valid || !strategy.fast[prev-1].Valid {
+		return nil
+	}
+
+	fast, slow := strategy.fast[prev].Values[0], strategy.slow[prev].Values[0]
+	if bars.Account.Holding() {
+		if fast < slow {
+			return []Order{{Buy: false}}
+		}
+
+		return nil
+	}
+
+	//	前一日刚刚上穿
+	if fast > slow && strategy.fast[prev-1].Values[0] <= strategy.slow[prev-1].Values[0] {
+		return []Order{{Buy: true}}
+	}
+
+	return nil
+}
+
+func (strategy *crossoverStrategy) OnFill(bars *Bars, fill Fill) []Order {
+	return nil
+}
+
+//	布林带突破策略：突破上轨时买入，跌破中轨时卖出
+type breakoutStrategy struct {
+	Peroid int
+	bands  []indicator.Point
+}
+
+func newBreakoutStrategy(peroid int) (*breakoutStrategy, error) {
+
+	bollinger, err := indicator.Get("Bollinger")
+	if err != nil {
+		return nil, err
+	}
+
+	err = indicator.CheckPeroid(bollinger, peroid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &breakoutStrategy{Peroid: peroid}, nil
+}
+
+func (strategy *breakoutStrategy) Name() string {
+	return fmt.Sprintf("Bollinger[Peroid = %d]", strategy.Peroid)
+}
+
+func (strategy *breakoutStrategy) Prepare(bars *Bars) error {
+
+	bollinger, err := indicator.Get("Bollinger")
+	if err != nil {
+		return err
+	}
+
+	strategy.bands, err = bars.Indicator(bollinger, strategy.Peroid)
+
+	return err
+}
+
+func (strategy *breakoutStrategy) OnBar(bars *Bars) []Order {
+
+	band := strategy.bands[bars.Index-1]
+	if !band.Valid {
+		return nil
+	}
+
+	//	Values依次为中轨、上轨、下轨
+	if bars.Account.Holding() {
+		return []Order{{Price: band.Values[0]}}
+	}
+
+	return []Order{{Buy: true, Price: band.Values[1]}}
+}
+
+func (strategy *breakoutStrategy) OnFill(bars *Bars, fill Fill) []Order {
+	if fill.Buy {
+		//	当日不再加仓，跌破中轨时卖出
+		return []Order{{Price: strategy.bands[bars.Index-1].Values[0]}}
+	}
+
+	//	卖出当日不再买入
+	return nil
+}
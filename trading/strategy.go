@@ -0,0 +1,218 @@
+package trading
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nzai/Tast/history"
+	"github.com/nzai/Tast/indicator"
+)
+
+//	交易策略
+//	测试一只股票时引擎先调用Prepare，然后每根K线开盘前调用OnBar取得当日的委托，
+//	委托成交后调用OnFill取得当日剩余的委托
+type Strategy interface {
+	//	策略名称(包含参数)
+	Name() string
+	//	测试一只股票前调用，载入策略需要的指标并重置状态
+	Prepare(bars *Bars) error
+	//	根据截至上一根K线的数据返回当日的委托
+	OnBar(bars *Bars) []Order
+	//	委托成交后调用，返回的委托替换当日剩余的委托
+	OnFill(bars *Bars, fill Fill) []Order
+}
+
+//	委托
+//	买入时Shares为0则按照Risk和RiskPerShare计算股数，两者都为0时全仓买入
+//	卖出时卖出全部头寸
+type Order struct {
+	Buy          bool
+	Price        float64 //	触发价，0表示以开盘价成交
+	Shares       int64   //	买入的股数
+	Risk         float64 //	每次买入承担的波动占账户权益的比例
+	RiskPerShare float64 //	每股承担的波动
+}
+
+//	成交
+type Fill struct {
+	Buy    bool
+	Price  float64
+	Shares int64
+}
+
+//	测试中的K线及账户
+type Bars struct {
+	Code      string
+	Timeframe history.Timeframe
+	Histories []history.DailyHistory
+	Index     int //	当前K线的位置，策略只能使用之前的K线和指标
+	Account   *Account
+	system    *TurtleTradingSystem
+	data      *stockData
+}
+
+//	当前K线的前一根K线
+func (bars *Bars) Prev() history.DailyHistory {
+	return bars.Histories[bars.Index-1]
+}
+
+//	获取与K线一一对应的技术指标
+func (bars *Bars) Indicator(ind indicator.Indicator, peroid int) ([]indicator.Point, error) {
+
+	all, err := getIndicator(bars.Code, bars.Timeframe, ind, len(bars.Histories))
+	if err != nil {
+		return nil, err
+	}
+
+	points, found := all[peroid]
+	if !found {
+		return nil, fmt.Errorf("股票%s缺少周期为%d的%s指标", bars.Code, peroid, ind.Name())
+	}
+
+	return points, nil
+}
+
+//	账户
+type Account struct {
+	Commission float64
+	Cash       float64
+	Shares     int64
+	Positions  []position
+	Trades     []Trade
+}
+
+//	是否持仓
+func (account *Account) Holding() bool {
+	return len(account.Positions) > 0
+}
+
+//	按指定价格计算的账户权益
+func (account *Account) Equity(price float64) float64 {
+	return account.Cash + float64(account.Shares)*price
+}
+
+//	买入一个头寸，返回买入的股数
+func (account *Account) buy(date string, price float64, order Order) int64 {
+
+	affordable := int64((account.Cash - account.Commission) / price)
+
+	shares := order.Shares
+	switch {
+	case shares > 0:
+	case order.Risk > 0 && order.RiskPerShare > 0:
+		shares = int64(order.Risk * account.Equity(price) / order.RiskPerShare)
+	default:
+		shares = affordable
+	}
+
+	if shares > affordable {
+		shares = affordable
+	}
+
+	if shares <= 0 {
+		return 0
+	}
+
+	account.Positions = append(account.Positions, position{Date: date, Shares: shares, Price: price})
+	account.Shares += shares
+	account.Cash -= float64(shares)*price + account.Commission
+
+	return shares
+}
+
+//	逐个头寸卖出全部股票
+func (account *Account) sellAll(date string, price float64) {
+	for _, p := range account.Positions {
+		account.Trades = append(account.Trades, Trade{
+			EnterDate:  p.Date,
+			EnterPrice: p.Price,
+			ExitDate:   date,
+			ExitPrice:  price,
+			Shares:     p.Shares,
+			Profit:     float64(p.Shares)*(price-p.Price) - account.Commission*2,
+		})
+
+		account.Cash += float64(p.Shares)*price - account.Commission
+	}
+
+	account.Positions = account.Positions[:0]
+	account.Shares = 0
+}
+
+//	根据描述创建策略，格式为 名称:参数1:参数2...
+//	turtle:Holding:N:Enter:Exit:Stop[:Trend:ADX:IndexTrend:VolatilityMin:VolatilityMax]
+//	macross:sma|ema|wma:快线周期:慢线周期
+//	bollinger:周期
+//	rule:规则文件路径
+func ParseStrategy(spec string) (Strategy, error) {
+
+	parts := strings.Split(strings.TrimSpace(spec), ":")
+	name, args := strings.ToLower(parts[0]), parts[1:]
+
+	switch {
+	case name == "rule" && len(args) > 0:
+		//	路径中可能有冒号
+		return LoadRule(strings.Join(args, ":"))
+	case name == "turtle" && (len(args) == 5 || len(args) == 10):
+		values, err := parseInts(spec, append(args, "0", "0", "0", "0", "0")[:10])
+		if err != nil {
+			return nil, err
+		}
+
+		parameter := TurtleTradingSystemParameter{
+			Holding:       values[0],
+			N:             values[1],
+			Enter:         values[2],
+			Exit:          values[3],
+			Stop:          values[4],
+			Trend:         values[5],
+			ADX:           values[6],
+			IndexTrend:    values[7],
+			VolatilityMin: values[8],
+			VolatilityMax: values[9]}
+		err = parameter.Check()
+		if err != nil {
+			return nil, err
+		}
+
+		return newTurtleStrategy(parameter), nil
+	case name == "macross" && len(args) == 3:
+		average, err := indicator.Get(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		values, err := parseInts(spec, args[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		return newCrossoverStrategy(average, values[0], values[1])
+	case name == "bollinger" && len(args) == 1:
+		values, err := parseInts(spec, args)
+		if err != nil {
+			return nil, err
+		}
+
+		return newBreakoutStrategy(values[0])
+	}
+
+	return nil, fmt.Errorf("不支持的策略:%s", spec)
+}
+
+//	解析整数参数
+func parseInts(spec string, args []string) ([]int, error) {
+
+	values := make([]int, 0, len(args))
+	for _, arg := range args {
+		value, err := strconv.Atoi(strings.TrimSpace(arg))
+		if err != nil {
+			return nil, fmt.Errorf("策略%s的参数%s不正确", spec, arg)
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
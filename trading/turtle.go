@@ -1,26 +1,155 @@
 package trading
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"os"
+	"math"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/nzai/Tast/atomicfile"
 	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/history"
+	"github.com/nzai/Tast/indicator"
+	"github.com/nzai/Tast/logging"
+	"github.com/nzai/Tast/metrics"
+	"github.com/nzai/Tast/peroidexterma"
 	"github.com/nzai/Tast/stock"
+	"github.com/nzai/Tast/turtle"
 )
 
 const (
-	dataFileName = "TradingSystem.txt"
+	dataFileName    = "TradingSystem.txt"
+	topResultsCount = 20               //	保留收益最高的参数组合数量
+	saveInterval    = time.Second * 10 //	保存测试进度的间隔
 )
 
+//	海龟交易系统的交易规则，所有参数组合共用
+type TurtleRules struct {
+	UnitRisk    float64 //	每个单位承担的波动占账户的比例
+	StopUnit    float64 //	止损参数的单位(N)
+	PyramidStep float64 //	每上涨多少N加仓一个单位
+}
+
 //	海龟交易系统参数
 type TurtleTradingSystemParameter struct {
-	Holding int
-	N       int
-	Enter   int
-	Exit    int
-	Stop    int
+	Holding       int
+	N             int
+	Enter         int
+	Exit          int
+	Stop          int
+	Trend         int //	收盘价高于该周期的均线时才入市，0表示不过滤
+	ADX           int //	ADX高于该值时才入市，0表示不过滤
+	IndexTrend    int //	指数收盘价高于该周期的均线时才入市，0表示不过滤
+	VolatilityMin int //	N占收盘价比例的百分位下限
+	VolatilityMax int //	N占收盘价比例的百分位上限，与下限都为0时不过滤
+}
+
+func (parameter TurtleTradingSystemParameter) String() string {
+	return fmt.Sprintf("[Holding = %d N = %d Enter = %d Exit = %d Stop = %d Trend = %d ADX = %d IndexTrend = %d Volatility = %d-%d]",
+		parameter.Holding,
+		parameter.N,
+		parameter.Enter,
+		parameter.Exit,
+		parameter.Stop,
+		parameter.Trend,
+		parameter.ADX,
+		parameter.IndexTrend,
+		parameter.VolatilityMin,
+		parameter.VolatilityMax)
+}
+
+//	检查参数是否有效，周期必须在指标计算的范围内，过滤参数不能为负数
+func (parameter TurtleTradingSystemParameter) Check() error {
+
+	if parameter.Holding <= 0 {
+		return fmt.Errorf("最大持仓数必须大于0:%d", parameter.Holding)
+	}
+
+	if parameter.Stop <= 0 {
+		return fmt.Errorf("止损参数必须大于0:%d", parameter.Stop)
+	}
+
+	err := turtle.CheckPeroid(parameter.N)
+	if err != nil {
+		return err
+	}
+
+	for _, peroid := range []int{parameter.Enter, parameter.Exit} {
+		err = peroidexterma.CheckPeroid(peroid)
+		if err != nil {
+			return err
+		}
+	}
+
+	if parameter.Trend < 0 || parameter.ADX < 0 || parameter.IndexTrend < 0 {
+		return fmt.Errorf("过滤参数不能为负数:%s", parameter)
+	}
+
+	if parameter.VolatilityMin < 0 || parameter.VolatilityMax > 100 ||
+		(parameter.VolatilityMax > 0 && parameter.VolatilityMin > parameter.VolatilityMax) {
+		return fmt.Errorf("波动性的百分位范围不正确:%d-%d", parameter.VolatilityMin, parameter.VolatilityMax)
+	}
+
+	return nil
+}
+
+//	参数对应的策略描述，可以用ParseStrategy解析
+func (parameter TurtleTradingSystemParameter) Spec() string {
+	return fmt.Sprintf("turtle:%d:%d:%d:%d:%d:%d:%d:%d:%d:%d",
+		parameter.Holding,
+		parameter.N,
+		parameter.Enter,
+		parameter.Exit,
+		parameter.Stop,
+		parameter.Trend,
+		parameter.ADX,
+		parameter.IndexTrend,
+		parameter.VolatilityMin,
+		parameter.VolatilityMax)
+}
+
+//	一组参数在所有股票上的测试结果
+type ParameterResult struct {
+	Parameter     TurtleTradingSystemParameter
+	Profit        float64
+	ProfitPercent float64
+	Trades        int
+	WinRate       float64 //	盈利交易占全部交易的比例
+}
+
+func (result ParameterResult) String() string {
+	return fmt.Sprintf("%s Profit = %.3f Profit = %.3f%% Trades = %d WinRate = %.3f%%",
+		result.Parameter,
+		result.Profit,
+		result.ProfitPercent*100,
+		result.Trades,
+		result.WinRate*100)
+}
+
+//	按收益倒序插入结果，只保留topResultsCount个
+func insertTop(top []ParameterResult, result ParameterResult) []ParameterResult {
+
+	index := sort.Search(len(top), func(i int) bool {
+		return top[i].Profit < result.Profit
+	})
+
+	if index >= topResultsCount {
+		return top
+	}
+
+	top = append(top, ParameterResult{})
+	copy(top[index+1:], top[index:])
+	top[index] = result
+
+	if len(top) > topResultsCount {
+		top = top[:topResultsCount]
+	}
+
+	return top
 }
 
 //	海龟交易系统
@@ -30,30 +159,53 @@ type TurtleTradingSystem struct {
 	Commission           float64
 	StartDate            string
 	EndDate              string
+	Timeframe            history.Timeframe
+	Volatility           turtle.Estimator
+	Fill                 string
+	Rules                TurtleRules
 	Start                TurtleTradingSystemParameter
 	End                  TurtleTradingSystemParameter
+	Step                 TurtleTradingSystemParameter
 	Current              TurtleTradingSystemParameter
 	CurrentProfit        float64
 	CurrentProfitPercent float64
 	Best                 TurtleTradingSystemParameter
 	BestProfit           float64
 	BestProfitPercent    float64
+	BestBenchmarks       []BenchmarkMetrics
+	Top                  []ParameterResult //	收益最高的参数组合，按收益倒序排列
 	CalculatingAmount    int64
 	CalculatedAmount     int64
 	CalculatedSeconds    int64
 	RemainTips           string
 }
 
-func Default() *TurtleTradingSystem {
-	stocks, err := stock.GetAll()
+func Default() (*TurtleTradingSystem, error) {
+
+	//	配置文件中没有指定股票时测试所有股票
+	codes := make([]string, 0)
+	for _, code := range strings.Split(config.GetString(configTradingSection, configCodesKey, ""), ",") {
+		if strings.TrimSpace(code) != "" {
+			codes = append(codes, strings.ToUpper(strings.TrimSpace(code)))
+		}
+	}
+
+	if len(codes) == 0 {
+		var err error
+		codes, err = stock.GetCodes()
+		if err != nil {
+			return nil, fmt.Errorf("获取股票列表时发生错误:%v", err)
+		}
+	}
+
+	timeframe, err := history.DefaultTimeframe()
 	if err != nil {
-		log.Fatal("获取股票列表时发生错误:", err)
-		return nil
+		return nil, fmt.Errorf("读取K线周期时发生错误:%v", err)
 	}
 
-	codes := make([]string, 0)
-	for _, s := range stocks {
-		codes = append(codes, s.Code)
+	volatility, err := turtle.ParseEstimator(config.GetString(configTradingSection, configVolatilityKey, string(turtle.WilderATR)))
+	if err != nil {
+		return nil, fmt.Errorf("读取波动性估计方法时发生错误:%v", err)
 	}
 
 	system := &TurtleTradingSystem{
@@ -62,6 +214,13 @@ func Default() *TurtleTradingSystem {
 		Commission:  7,
 		StartDate:   "20060101",
 		EndDate:     "20141231",
+		Timeframe:   timeframe,
+		Volatility:  volatility,
+		Fill:        config.GetString(configTradingSection, configFillKey, FillPessimistic),
+		Rules: TurtleRules{
+			UnitRisk:    config.GetFloat64(configTradingSection, configUnitRiskKey, 0.01),
+			StopUnit:    config.GetFloat64(configTradingSection, configStopUnitKey, 0.1),
+			PyramidStep: config.GetFloat64(configTradingSection, configPyramidStepKey, 0.5)},
 		Start: TurtleTradingSystemParameter{
 			Holding: 2,
 			N:       2,
@@ -74,6 +233,12 @@ func Default() *TurtleTradingSystem {
 			Enter:   50,
 			Exit:    50,
 			Stop:    50},
+		Step: TurtleTradingSystemParameter{
+			Holding: 1,
+			N:       1,
+			Enter:   1,
+			Exit:    1,
+			Stop:    1},
 		Current: TurtleTradingSystemParameter{
 			Holding: 2,
 			N:       2,
@@ -94,19 +259,87 @@ func Default() *TurtleTradingSystem {
 		RemainTips:        "计算尚未开始",
 	}
 
-	system.CalculatingAmount = int64(len(system.Codes) *
-		(system.End.Holding - system.Start.Holding + 1) *
-		(system.End.N - system.Start.N + 1) *
-		(system.End.Enter - system.Start.Enter + 1) *
-		(system.End.Exit - system.Start.Exit + 1) *
-		(system.End.Stop - system.Start.Stop + 1))
+	//	入市过滤条件
+	err = loadFilters(system)
+	if err != nil {
+		return nil, fmt.Errorf("读取入市过滤条件时发生错误:%v", err)
+	}
+
+	system.CalculatingAmount = int64(len(system.Codes)) * parameterCount(system.Start, system.End, system.Step)
+
+	return system, nil
+}
+
+var (
+	defaultSystem      *TurtleTradingSystem
+	defaultSystemMutex sync.Mutex
+	progressMutex      sync.RWMutex //	保护测试进度，供其他goroutine读取
+)
+
+//	当前的交易系统，第一次使用时根据配置创建，命令行参数可以在此之前修改配置
+func currentSystem() (*TurtleTradingSystem, error) {
+
+	defaultSystemMutex.Lock()
+	defer defaultSystemMutex.Unlock()
+
+	if defaultSystem == nil {
+		system, err := Default()
+		if err != nil {
+			return nil, err
+		}
+		defaultSystem = system
+	}
+
+	return defaultSystem, nil
+}
+
+//	丢弃当前的交易系统以及缓存的股票数据，下次使用时根据配置重新创建
+func Reset() {
+
+	defaultSystemMutex.Lock()
+	defaultSystem = nil
+	defaultSystemMutex.Unlock()
 
-	return system
+	stockDataMutex.Lock()
+	stockDataCache = make(map[string]*stockData)
+	stockDataMutex.Unlock()
+
+	indicatorMutex.Lock()
+	indicatorCache = make(map[string]map[int][]indicator.Point)
+	indicatorMutex.Unlock()
+
+	filterMutex.Lock()
+	filterCache = make(map[string][]bool)
+	filterMutex.Unlock()
+
+	//	基准与股票列表、测试区间、资金和手续费有关
+	benchmarkMutex.Lock()
+	benchmarkCache = make(map[string]*Series)
+	benchmarkMutex.Unlock()
 }
 
-var currentTurtleTradingSystem *TurtleTradingSystem = Default()
+//	当前交易系统测试进度的副本，交易系统尚未创建时返回false
+func Progress() (TurtleTradingSystem, bool) {
 
-func saveSystem() error {
+	defaultSystemMutex.Lock()
+	system := defaultSystem
+	defaultSystemMutex.Unlock()
+
+	if system == nil {
+		return TurtleTradingSystem{}, false
+	}
+
+	progressMutex.RLock()
+	defer progressMutex.RUnlock()
+
+	snapshot := *system
+	snapshot.Top = append([]ParameterResult(nil), system.Top...)
+
+	return snapshot, true
+}
+
+//	保存交易系统的测试进度
+func saveSystem(currentTurtleTradingSystem *TurtleTradingSystem) error {
 	dataDir, err := config.GetDataDir()
 	if err != nil {
 		return err
@@ -114,7 +347,7 @@ func saveSystem() error {
 
 	filePath := filepath.Join(dataDir, dataFileName)
 	//	打开文件
-	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0x777)
+	file, err := atomicfile.Create(filePath)
 	if err != nil {
 		return err
 	}
@@ -125,56 +358,395 @@ func saveSystem() error {
 	file.WriteString(fmt.Sprintf("Commission = %f\n", currentTurtleTradingSystem.Commission))
 	file.WriteString(fmt.Sprintf("StartDate = %s\n", currentTurtleTradingSystem.StartDate))
 	file.WriteString(fmt.Sprintf("EndDate = %s\n", currentTurtleTradingSystem.EndDate))
-	file.WriteString(fmt.Sprintf("Start\t[Holding = %d N = %d Enter = %d Exit = %d Stop = %d]\n",
-		currentTurtleTradingSystem.Start.Holding,
-		currentTurtleTradingSystem.Start.N,
-		currentTurtleTradingSystem.Start.Enter,
-		currentTurtleTradingSystem.Start.Exit,
-		currentTurtleTradingSystem.Start.Stop))
-	file.WriteString(fmt.Sprintf("End\t[Holding = %d N = %d Enter = %d Exit = %d Stop = %d]\n",
-		currentTurtleTradingSystem.End.Holding,
-		currentTurtleTradingSystem.End.N,
-		currentTurtleTradingSystem.End.Enter,
-		currentTurtleTradingSystem.End.Exit,
-		currentTurtleTradingSystem.End.Stop))
-	file.WriteString(fmt.Sprintf("Current\t[Holding = %d N = %d Enter = %d Exit = %d Stop = %d]\n",
-		currentTurtleTradingSystem.Current.Holding,
-		currentTurtleTradingSystem.Current.N,
-		currentTurtleTradingSystem.Current.Enter,
-		currentTurtleTradingSystem.Current.Exit,
-		currentTurtleTradingSystem.Current.Stop))
+	file.WriteString(fmt.Sprintf("Timeframe = %s\n", currentTurtleTradingSystem.Timeframe))
+	file.WriteString(fmt.Sprintf("Volatility = %s\n", currentTurtleTradingSystem.Volatility))
+	file.WriteString(fmt.Sprintf("Fill = %s\n", currentTurtleTradingSystem.Fill))
+	file.WriteString(fmt.Sprintf("Start\t%s\n", currentTurtleTradingSystem.Start))
+	file.WriteString(fmt.Sprintf("End\t%s\n", currentTurtleTradingSystem.End))
+	file.WriteString(fmt.Sprintf("Step\t%s\n", currentTurtleTradingSystem.Step))
+	file.WriteString(fmt.Sprintf("Current\t%s\n", currentTurtleTradingSystem.Current))
 	file.WriteString(fmt.Sprintf("CurrentProfit = %.3f\n", currentTurtleTradingSystem.CurrentProfit))
 	file.WriteString(fmt.Sprintf("CurrentProfit = %.3f%%\n", currentTurtleTradingSystem.CurrentProfitPercent*100))
-	file.WriteString(fmt.Sprintf("Best\t[Holding = %d N = %d Enter = %d Exit = %d Stop = %d]\n",
-		currentTurtleTradingSystem.Best.Holding,
-		currentTurtleTradingSystem.Best.N,
-		currentTurtleTradingSystem.Best.Enter,
-		currentTurtleTradingSystem.Best.Exit,
-		currentTurtleTradingSystem.Best.Stop))
+	file.WriteString(fmt.Sprintf("Best\t%s\n", currentTurtleTradingSystem.Best))
 	file.WriteString(fmt.Sprintf("BestProfit = %.3f\n", currentTurtleTradingSystem.BestProfit))
 	file.WriteString(fmt.Sprintf("BestProfit = %.3f%%\n", currentTurtleTradingSystem.BestProfitPercent*100))
+	for _, benchmark := range currentTurtleTradingSystem.BestBenchmarks {
+		file.WriteString(fmt.Sprintf("Benchmark\t[%s]\n", benchmark))
+	}
+	for _, result := range currentTurtleTradingSystem.Top {
+		file.WriteString(fmt.Sprintf("Top\t%s\n", result))
+	}
 	file.WriteString(fmt.Sprintf("CalculatingAmount = %d\n", currentTurtleTradingSystem.CalculatingAmount))
 	file.WriteString(fmt.Sprintf("CalculatedAmount = %d\n", currentTurtleTradingSystem.CalculatedAmount))
 	file.WriteString(fmt.Sprintf("CalculatedSeconds = %d\n", currentTurtleTradingSystem.CalculatedSeconds))
 	file.WriteString(fmt.Sprintf("RemainTips = %s\n", currentTurtleTradingSystem.RemainTips))
 
-	return nil
+	return file.Commit()
 }
 
-func TestAll() error {
-	log.Print("开始测试海龟交易系统")
+//	测试海龟交易系统的所有参数组合，ctx被取消时保存当前进度后返回
+func TestAll(ctx context.Context) error {
+	logger := logging.Stage("sweep")
+	logger.Info("开始测试海龟交易系统")
+
+	system, err := currentSystem()
+	if err != nil {
+		return err
+	}
+
+	startTime := time.Now()
+	lastSaveTime := startTime
+	for {
+		if ctx.Err() != nil {
+			progressMutex.Lock()
+			system.RemainTips = "计算被取消"
+			progressMutex.Unlock()
+
+			err := saveSystem(system)
+			if err != nil {
+				return err
+			}
+
+			logger.Warn("海龟交易系统测试被取消", "current", system.Current.String())
+			return ctx.Err()
+		}
+
+		//	测试当前参数在所有股票上的表现
+		results := make([]*TradingResult, 0, len(system.Codes))
+		var profit float64
+		var trades, wins int
+		for _, code := range system.Codes {
+			result, err := TestStock(code, system.Current)
+			if err != nil {
+				return err
+			}
+
+			results = append(results, result)
+			profit += result.Profit
+			trades += len(result.Trades)
+			for _, trade := range result.Trades {
+				if trade.Profit > 0 {
+					wins++
+				}
+			}
+		}
+
+		//	最优参数与基准进行比较
+		var benchmarks []BenchmarkMetrics
+		better := system.CalculatedAmount == 0 || profit > system.BestProfit
+		if better {
+			var err error
+			benchmarks, err = compareAggregate(results)
+			if err != nil {
+				return err
+			}
+		}
+
+		progressMutex.Lock()
+		system.CurrentProfit = profit
+		system.CurrentProfitPercent = profit / (system.StartAmount * float64(len(system.Codes)))
+		if better {
+			system.Best = system.Current
+			system.BestProfit = system.CurrentProfit
+			system.BestProfitPercent = system.CurrentProfitPercent
+			system.BestBenchmarks = benchmarks
+		}
+
+		result := ParameterResult{
+			Parameter:     system.Current,
+			Profit:        system.CurrentProfit,
+			ProfitPercent: system.CurrentProfitPercent,
+			Trades:        trades,
+		}
+		if trades > 0 {
+			result.WinRate = float64(wins) / float64(trades)
+		}
+		system.Top = insertTop(system.Top, result)
+
+		system.CalculatedAmount += int64(len(system.Codes))
+		system.CalculatedSeconds = int64(time.Now().Sub(startTime).Seconds())
+		system.RemainTips = remainTips(system)
+
+		finished := !nextParameter(&system.Current, system.Start, system.End, system.Step)
+		progressMutex.Unlock()
+
+		metrics.SweepCompletion.Set("", float64(system.CalculatedAmount)/float64(system.CalculatingAmount))
+		metrics.SweepBestProfit.Set("", system.BestProfit)
+		if system.CalculatedSeconds > 0 {
+			metrics.BacktestsPerSecond.Set("", float64(system.CalculatedAmount)/float64(system.CalculatedSeconds))
+		}
+
+		if finished {
+			break
+		}
+
+		//	定时保存进度
+		if time.Now().Sub(lastSaveTime) > saveInterval {
+			err := saveSystem(system)
+			if err != nil {
+				return err
+			}
+			lastSaveTime = time.Now()
+		}
+	}
+
+	progressMutex.Lock()
+	system.RemainTips = "计算完成"
+	progressMutex.Unlock()
 
 	//	保存系统
-	err := saveSystem()
+	err = saveSystem(system)
 	if err != nil {
 		return err
 	}
 
-	log.Print("海龟交易系统测试结束")
+	logger.Info("海龟交易系统测试结束", "best", system.Best.String(), "profit", system.BestProfit)
+
+	return nil
+}
+
+//	预计剩余时间
+func remainTips(system *TurtleTradingSystem) string {
+	if system.CalculatedAmount == 0 || system.CalculatedSeconds == 0 {
+		return "计算尚未开始"
+	}
+
+	remainAmount := system.CalculatingAmount - system.CalculatedAmount
+	remainSeconds := float64(system.CalculatedSeconds) * float64(remainAmount) / float64(system.CalculatedAmount)
+
+	return fmt.Sprintf("已完成%.3f%%，预计还需%v",
+		float64(system.CalculatedAmount)*100/float64(system.CalculatingAmount),
+		time.Duration(remainSeconds)*time.Second)
+}
+
+//	参数的遍历范围
+type parameterField struct {
+	value            *int
+	start, end, step int
+}
+
+//	参数按照Stop、Exit、Enter、N、Holding以及各入市过滤条件的顺序遍历
+func parameterFields(current *TurtleTradingSystemParameter, start, end, step TurtleTradingSystemParameter) []parameterField {
+	return []parameterField{
+		{&current.Stop, start.Stop, end.Stop, step.Stop},
+		{&current.Exit, start.Exit, end.Exit, step.Exit},
+		{&current.Enter, start.Enter, end.Enter, step.Enter},
+		{&current.N, start.N, end.N, step.N},
+		{&current.Holding, start.Holding, end.Holding, step.Holding},
+		{&current.Trend, start.Trend, end.Trend, step.Trend},
+		{&current.ADX, start.ADX, end.ADX, step.ADX},
+		{&current.IndexTrend, start.IndexTrend, end.IndexTrend, step.IndexTrend},
+		{&current.VolatilityMin, start.VolatilityMin, end.VolatilityMin, step.VolatilityMin},
+		{&current.VolatilityMax, start.VolatilityMax, end.VolatilityMax, step.VolatilityMax},
+	}
+}
+
+//	按照步长递增参数，全部遍历完毕时返回false
+func nextParameter(current *TurtleTradingSystemParameter, start, end, step TurtleTradingSystemParameter) bool {
+
+	for _, field := range parameterFields(current, start, end, step) {
+		if field.step <= 0 {
+			field.step = 1
+		}
+
+		if *field.value+field.step <= field.end {
+			*field.value += field.step
+			return true
+		}
+
+		*field.value = field.start
+	}
+
+	return false
+}
+
+//	参数组合的数量
+func parameterCount(start, end, step TurtleTradingSystemParameter) int64 {
+
+	var current TurtleTradingSystemParameter
+	count := int64(1)
+	for _, field := range parameterFields(&current, start, end, step) {
+		if field.step <= 0 {
+			field.step = 1
+		}
+
+		if field.end >= field.start {
+			count *= int64((field.end-field.start)/field.step + 1)
+		}
+	}
+
+	return count
+}
+
+//	头寸
+type position struct {
+	Date   string
+	Shares int64
+	Price  float64
+}
+
+//	交易记录
+type Trade struct {
+	EnterDate  string
+	EnterPrice float64
+	ExitDate   string
+	ExitPrice  float64
+	Shares     int64
+	Profit     float64
+}
+
+//	单只股票的测试结果
+type TradingResult struct {
+	Code          string
+	Strategy      string
+	Parameter     TurtleTradingSystemParameter //	海龟交易系统的参数，其他策略为空
+	StartAmount   float64
+	EndAmount     float64
+	Profit        float64
+	ProfitPercent float64
+	Trades        []Trade
+	Equity        Series
+	Benchmarks    []BenchmarkMetrics //	调用Compare之后才有
+}
+
+//	与买入持有、等权组合及指数进行比较，结果保存在Benchmarks中，
+//	比较需要读取基准数据，因此Backtest不自动进行，只在需要时调用
+func (result *TradingResult) Compare() error {
+
+	benchmarks, err := compareStock(result)
+	if err != nil {
+		return err
+	}
+	result.Benchmarks = benchmarks
 
 	return nil
 }
 
-func TestStock(code string) error {
+//	用指定参数测试海龟交易系统在一只股票上的表现
+func TestStock(code string, parameter TurtleTradingSystemParameter) (*TradingResult, error) {
+
+	result, err := Backtest(code, newTurtleStrategy(parameter))
+	if err != nil {
+		return nil, err
+	}
+	result.Parameter = parameter
+
+	return result, nil
+}
+
+//	按照海龟规则交易的策略
+type turtleStrategy struct {
+	Parameter    TurtleTradingSystemParameter
+	StopDistance float64
+	rules        TurtleRules
+	turtles      []turtle.TurtleIndex
+	enters       []peroidexterma.PeroidExtermaIndex
+	exits        []peroidexterma.PeroidExtermaIndex
+	allowed      []bool //	入市过滤条件，nil表示不过滤
+	stop         float64
+
+	//	当日的状态
+	n          float64
+	enterPrice float64
+	exitPrice  float64
+	exited     bool
+	ready      bool //	指标是否已经预热完成
+	permitted  bool //	入市过滤条件是否满足
+}
+
+func newTurtleStrategy(parameter TurtleTradingSystemParameter) *turtleStrategy {
+	return &turtleStrategy{Parameter: parameter}
+}
+
+func (strategy *turtleStrategy) Name() string {
+	return "Turtle" + strategy.Parameter.String()
+}
+
+func (strategy *turtleStrategy) Prepare(bars *Bars) error {
+
+	parameter := strategy.Parameter
+	turtles, found := bars.data.Turtles[parameter.N]
+	if !found {
+		return fmt.Errorf("股票%s缺少周期为%d的海龟指标", bars.Code, parameter.N)
+	}
+
+	enters, found := bars.data.Extermas[parameter.Enter]
+	if !found {
+		return fmt.Errorf("股票%s缺少周期为%d的区间极值指标", bars.Code, parameter.Enter)
+	}
+
+	exits, found := bars.data.Extermas[parameter.Exit]
+	if !found {
+		return fmt.Errorf("股票%s缺少周期为%d的区间极值指标", bars.Code, parameter.Exit)
+	}
+
+	allowed, err := entryFilter(bars, parameter)
+	if err != nil {
+		return err
+	}
+
+	strategy.turtles, strategy.enters, strategy.exits, strategy.allowed = turtles, enters, exits, allowed
+	strategy.rules = bars.system.Rules
+	strategy.StopDistance = float64(parameter.Stop) * strategy.rules.StopUnit
+	strategy.stop = 0
+
 	return nil
 }
+
+//	使用前一日收盘后的指标决定当日的交易，指标预热完成之前不入市
+func (strategy *turtleStrategy) OnBar(bars *Bars) []Order {
+
+	prev := bars.Index - 1
+	strategy.ready = strategy.turtles[prev].Valid && strategy.enters[prev].Valid && strategy.exits[prev].Valid
+	strategy.n = strategy.turtles[prev].N
+	strategy.enterPrice = strategy.enters[prev].Max
+	strategy.exitPrice = strategy.exits[prev].Min
+	if math.IsNaN(strategy.exitPrice) {
+		//	退出指标无效时只依靠止损
+		strategy.exitPrice = 0
+	}
+	strategy.permitted = strategy.allowed == nil || strategy.allowed[prev]
+	strategy.exited = false
+
+	return strategy.orders(bars.Account)
+}
+
+func (strategy *turtleStrategy) OnFill(bars *Bars, fill Fill) []Order {
+
+	if fill.Buy {
+		strategy.stop = fill.Price - strategy.StopDistance*strategy.n
+	} else {
+		//	退出当日不再入市
+		strategy.exited = true
+	}
+
+	return strategy.orders(bars.Account)
+}
+
+//	当前的委托
+func (strategy *turtleStrategy) orders(account *Account) []Order {
+
+	orders := make([]Order, 0, 2)
+	if account.Holding() {
+		//	止损或者退出
+		price := math.Max(strategy.stop, strategy.exitPrice)
+		if price > 0 {
+			orders = append(orders, Order{Price: price})
+		}
+	}
+
+	//	突破入市或者加仓，不满足入市过滤条件时不开新仓
+	if strategy.ready && !strategy.exited && strategy.n > 0 && len(account.Positions) < strategy.Parameter.Holding &&
+		(account.Holding() || strategy.permitted) {
+		price := strategy.enterPrice
+		if account.Holding() {
+			//	每上涨PyramidStep个N加仓一个单位
+			price = account.Positions[len(account.Positions)-1].Price + strategy.n*strategy.rules.PyramidStep
+		}
+
+		//	每个单位的波动为账户的UnitRisk
+		orders = append(orders, Order{Buy: true, Price: price, Risk: strategy.rules.UnitRisk, RiskPerShare: strategy.n})
+	}
+
+	return orders
+}
@@ -0,0 +1,209 @@
+package turtle
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/history"
+	"github.com/nzai/Tast/storage"
+)
+
+const (
+	configSection       = "turtle"
+	configEstimatorsKey = "estimators"
+)
+
+//	波动性估计方法，计算结果都以价格为单位，可以直接作为N使用
+type Estimator string
+
+const (
+	WilderATR   Estimator = "wilder"      //	Wilder平滑的真实波动幅度均值(海龟交易法则原版)
+	SimpleATR   Estimator = "sma"         //	简单平均的真实波动幅度均值
+	EMAATR      Estimator = "ema"         //	指数平均的真实波动幅度均值
+	StdDev      Estimator = "stddev"      //	收盘价对数收益率的标准差
+	Parkinson   Estimator = "parkinson"   //	基于最高最低价的Parkinson估计
+	GarmanKlass Estimator = "garmanklass" //	基于开高低收的Garman-Klass估计
+)
+
+//	所有的波动性估计方法
+var estimators = []Estimator{WilderATR, SimpleATR, EMAATR, StdDev, Parkinson, GarmanKlass}
+
+func init() {
+	for _, estimator := range estimators {
+		storage.RegisterIndicatorKind(estimator.Kind())
+	}
+}
+
+//	解析波动性估计方法
+func ParseEstimator(value string) (Estimator, error) {
+
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return WilderATR, nil
+	}
+
+	for _, estimator := range estimators {
+		if string(estimator) == value {
+			return estimator, nil
+		}
+	}
+
+	return "", fmt.Errorf("不支持的波动性估计方法:%s", value)
+}
+
+//	配置文件中指定需要计算的波动性估计方法
+func DefaultEstimators() ([]Estimator, error) {
+
+	values := strings.Split(config.GetString(configSection, configEstimatorsKey, string(WilderATR)), ",")
+	list := make([]Estimator, 0, len(values))
+	for _, value := range values {
+		estimator, err := ParseEstimator(value)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, estimator)
+	}
+
+	return list, nil
+}
+
+//	各估计方法的存储种类，Wilder沿用原来的海龟指标
+var estimatorKinds = map[Estimator]string{
+	WilderATR:   storage.KindTurtle,
+	SimpleATR:   storage.KindTurtle + "SMA",
+	EMAATR:      storage.KindTurtle + "EMA",
+	StdDev:      storage.KindTurtle + "StdDev",
+	Parkinson:   storage.KindTurtle + "Parkinson",
+	GarmanKlass: storage.KindTurtle + "GarmanKlass",
+}
+
+//	存储种类
+func (estimator Estimator) Kind() string {
+	return estimatorKinds[estimator]
+}
+
+//	真实波动幅度，第一天没有前收盘价，只用当天的最高最低价
+func trueRange(histories []history.DailyHistory, index int) float64 {
+
+	h := histories[index]
+	if index == 0 {
+		return h.High - h.Low
+	}
+
+	pdc := histories[index-1].Close
+	return math.Max(h.High-h.Low, math.Max(h.High-pdc, pdc-h.Low))
+}
+
+//	根据股价历史计算周期为peroid的波动性
+func calculate(histories []history.DailyHistory, peroid int, estimator Estimator) ([]TurtleIndex, error) {
+
+	peroid64 := float64(peroid)
+	trs := make([]float64, len(histories))
+	for index := range histories {
+		trs[index] = trueRange(histories, index)
+	}
+
+	//	窗口内的逐日数值，统计类估计使用
+	var samples []float64
+	switch estimator {
+	case StdDev:
+		samples = make([]float64, len(histories))
+		for index := 1; index < len(histories); index++ {
+			samples[index] = math.Log(histories[index].Close / histories[index-1].Close)
+		}
+	case Parkinson:
+		samples = make([]float64, len(histories))
+		for index, h := range histories {
+			hl := math.Log(h.High / h.Low)
+			samples[index] = hl * hl / (4 * math.Ln2)
+		}
+	case GarmanKlass:
+		samples = make([]float64, len(histories))
+		for index, h := range histories {
+			hl := math.Log(h.High / h.Low)
+			co := math.Log(h.Close / h.Open)
+			samples[index] = 0.5*hl*hl - (2*math.Ln2-1)*co*co
+		}
+	}
+
+	list := make([]TurtleIndex, 0, len(histories))
+	n, sum := math.NaN(), 0.0
+	for index, h := range histories {
+
+		//	窗口内TR的移动求和
+		sum += trs[index]
+		if index >= peroid {
+			sum -= trs[index-peroid]
+		}
+
+		//	窗口[start, index]，窗口未满之前N为NaN
+		start := index - peroid + 1
+		if start >= 0 {
+			switch estimator {
+			case SimpleATR:
+				n = sum / peroid64
+			case WilderATR, EMAATR:
+				if start == 0 {
+					//	用前peroid天TR的简单平均作为初始值
+					n = sum / peroid64
+				} else if estimator == WilderATR {
+					n = ((peroid64-1)*n + trs[index]) / peroid64
+				} else {
+					n += 2 / (peroid64 + 1) * (trs[index] - n)
+				}
+			case StdDev:
+				//	第一天没有收益率，需要peroid个收益率
+				if start >= 1 {
+					n = standardDeviation(samples[start:index+1]) * h.Close
+				}
+			case Parkinson, GarmanKlass:
+				n = math.Sqrt(math.Max(mean(samples[start:index+1]), 0)) * h.Close
+			default:
+				return nil, fmt.Errorf("不支持的波动性估计方法:%s", estimator)
+			}
+		}
+
+		list = append(list, TurtleIndex{
+			Code:   h.Code,
+			Peroid: peroid,
+			Date:   h.Date,
+			N:      n,
+			TR:     trs[index],
+			Valid:  !math.IsNaN(n),
+		})
+	}
+
+	return list, nil
+}
+
+//	平均值
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+
+	return sum / float64(len(values))
+}
+
+//	样本标准差
+func standardDeviation(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+
+	average := mean(values)
+	var sum float64
+	for _, value := range values {
+		sum += (value - average) * (value - average)
+	}
+
+	return math.Sqrt(sum / float64(len(values)-1))
+}
@@ -1,20 +1,17 @@
 package turtle
 
 import (
-	"bufio"
+	"context"
 	"errors"
 	"fmt"
-	"log"
 	"math"
-	"os"
-	"path/filepath"
 
-	"strconv"
-	"strings"
-
-	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/failure"
 	"github.com/nzai/Tast/history"
+	"github.com/nzai/Tast/logging"
 	"github.com/nzai/Tast/stock"
+	"github.com/nzai/Tast/storage"
+	"github.com/nzai/Tast/workpool"
 )
 
 type TurtleIndex struct {
@@ -23,201 +20,206 @@ type TurtleIndex struct {
 	Date   string
 	N      float64 //	波动性均值
 	TR     float64 //	真实波动性
+	Valid  bool    //	窗口是否已满，未满时N为NaN
 }
 
 const (
-	peroidMin    = 2
-	peroidMax    = 50
-	dataFileName = "Turtle.txt"
+	peroidMin = 2
+	peroidMax = 50
 )
 
-//	更新海龟指数
-func UpdateAll() error {
-
-	log.Println("开始更新海龟指标")
+//	指标计算方法的版本，改变后已保存的指标需要重新计算，版本2开始窗口未满时N为NaN
+const indexVersion = 2
 
-	//	数据保存目录
-	dataDir, err := config.GetDataDir()
-	if err != nil {
-		return err
-	}
+//	更新所有股票的海龟指数
+func UpdateAll(ctx context.Context) error {
 
 	//	获取所有股票
-	stocks, err := stock.GetAll()
+	codes, err := stock.GetCodes()
 	if err != nil {
 		return err
 	}
 
-	//log.Printf("共有股票%d只", len(stocks))
+	return Update(ctx, codes)
+}
 
-	for _, stock := range stocks {
-		//	更新每只股票的指标
-		err = updateStock(stock.Code, dataDir)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
+//	更新指定股票的海龟指数
+func Update(ctx context.Context, codes []string) error {
 
-	log.Println("海龟指标更新完毕")
+	logger := logging.Stage("turtle")
+	logger.Info("开始更新海龟指标", "codes", len(codes))
 
-	return err
-}
+	//	数据存储
+	store, err := storage.Default()
+	if err != nil {
+		return err
+	}
 
-func updateStock(code string, dataDir string) error {
-	//	获取股票每日历史
-	histories, err := history.GetStockDailyHistory(code, dataDir)
+	//	K线周期
+	timeframe, err := history.DefaultTimeframe()
 	if err != nil {
 		return err
 	}
 
-	filePath := filepath.Join(dataDir, code, dataFileName)
-	_, err = os.Stat(filePath)
-	if !os.IsNotExist(err) {
-		//	如果文件存在就跳过不重新计算
-		return nil
+	//	波动性估计方法
+	estimators, err := DefaultEstimators()
+	if err != nil {
+		return err
 	}
-	//log.Printf("股票%s历史记录有%d天", code, len(histories))
 
-	allIndex := make(map[int][]TurtleIndex)
-	chanReceive := make(chan int)
-
-	//	并发计算指标
-	go func() {
-		for peroid := peroidMin; peroid <= peroidMax; peroid++ {
-			go func(p int) {
-				//	更新股票在周期为peroid时的指数
-				indexes, err := calculate(histories, p)
-				if err != nil {
-					log.Fatal(err)
-				}
-
-				allIndex[p] = indexes
-				chanReceive <- 1
-			}(peroid)
-		}
-	}()
+	//log.Printf("共有股票%d只", len(stocks))
 
-	//	阻塞，直到所有股票更新完历史
-	for peroid := peroidMin; peroid <= peroidMax; peroid++ {
-		<-chanReceive
+	failures := failure.New("turtle")
+	err = workpool.Calculate(ctx, workpool.Shared(), codes, prepare(timeframe, estimators, store), failures.Record, failures.Stopped)
+	if err != nil {
+		logger.Warn("海龟指标更新被取消")
+		return err
 	}
 
-	//	保存
-	return save(code, allIndex, filePath)
+	logger.Info("海龟指标更新完毕")
+
+	return failures.Err()
 }
 
-//	根据股价历史计算指标
-func calculate(histories []history.DailyHistory, peroid int) ([]TurtleIndex, error) {
-
-	peroid64 := float64(peroid)
-	var n, prevn, pdc, tr float64
-	list := make([]TurtleIndex, 0)
-	for index, history := range histories {
-		if index == 0 {
-			pdc = 0
-		} else {
-			pdc = histories[index-1].Close
+//	一只股票尚未用当前版本的方法计算的各种海龟指标，按周期拆分计算
+func prepare(timeframe history.Timeframe, estimators []Estimator, store storage.Store) workpool.Prepare {
+	return func(code string) ([]workpool.Kind, error) {
+
+		//	获取股票在该周期下的K线
+		histories, err := history.GetStockBars(code, timeframe)
+		if err != nil {
+			return nil, err
 		}
 
-		tr = math.Max(history.High-history.Low, math.Max(history.High-pdc, pdc-history.Low))
+		kinds := make([]workpool.Kind, 0, len(estimators))
+		for _, estimator := range estimators {
+			kind := timeframe.Kind(estimator.Kind())
+			found, err := storage.Current(store, code, kind, indexVersion)
+			if err != nil {
+				return nil, err
+			}
 
-		if index == 0 {
-			n = tr / peroid64
-		} else {
-			n = ((peroid64-1)*prevn + tr) / peroid64
+			if found {
+				//	已经用当前版本的方法保存过的就跳过不重新计算
+				continue
+			}
+
+			estimator := estimator
+			kinds = append(kinds, workpool.Kind{
+				Peroids: peroids(),
+				Calculate: func(peroid int) (interface{}, error) {
+					return calculate(histories, peroid, estimator)
+				},
+				Save: func(results map[int]interface{}) error {
+					allIndex := make(map[int][]TurtleIndex, len(results))
+					for peroid, result := range results {
+						allIndex[peroid] = result.([]TurtleIndex)
+					}
+
+					return save(code, kind, allIndex, store)
+				},
+			})
 		}
 
-		list = append(list, TurtleIndex{
-			Code:   history.Code,
-			Peroid: peroid,
-			Date:   history.Date,
-			N:      n,
-			TR:     tr,
-		})
+		return kinds, nil
 	}
-
-	return list, nil
 }
 
-//	将指标保存到文件
-func save(code string, allIndex map[int][]TurtleIndex, filePath string) error {
-	//	打开文件
-	file, err := os.OpenFile(filePath, os.O_CREATE, 0x777)
-	if err != nil {
-		return err
+//	计算的周期
+func peroids() []int {
+	list := make([]int, 0, peroidMax-peroidMin+1)
+	for peroid := peroidMin; peroid <= peroidMax; peroid++ {
+		list = append(list, peroid)
 	}
-	defer file.Close()
 
-	for peroid := peroidMin; peroid <= peroidMax; peroid++ {
+	return list
+}
 
-		indexes, found := allIndex[peroid]
-		if !found {
-			return errors.New(fmt.Sprintf("保存海龟指标时发现缺失code=%s peroid=%d的指标", code, peroid))
-		}
+//	检查周期是否在计算的范围内
+func CheckPeroid(peroid int) error {
 
-		for _, index := range indexes {
-			line := fmt.Sprintf("%d\t%s\t%.6f\t%.6f\n",
-				index.Peroid,
-				index.Date,
-				index.N,
-				index.TR)
-
-			//	将股价写入文件
-			_, err = file.WriteString(line)
-			if err != nil {
-				return err
-			}
-		}
+	if peroid < peroidMin || peroid > peroidMax {
+		return fmt.Errorf("海龟指标不支持周期%d，支持的周期为%d到%d", peroid, peroidMin, peroidMax)
 	}
 
 	return nil
 }
 
-//	从文件中读入指标
-func load(code, filePath string) (map[int][]TurtleIndex, error) {
-	file, err := os.Open(filePath)
+//	获取股票用指定方法估计波动性的海龟指标
+func GetStockIndex(code string, timeframe history.Timeframe, estimator Estimator) (map[int][]TurtleIndex, error) {
+
+	//	数据存储
+	store, err := storage.Default()
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	allIndex := make(map[int][]TurtleIndex)
-	for scanner.Scan() {
-		parts := strings.Split(scanner.Text(), "\t")
-		if len(parts) != 4 {
-			return nil, errors.New("股票列表文件格式不正确")
-		}
+	kind := timeframe.Kind(estimator.Kind())
+	found, err := storage.Current(store, code, kind, indexVersion)
+	if err != nil {
+		return nil, err
+	}
 
-		peroid64, err := strconv.ParseInt(parts[0], 10, 64)
+	if !found {
+		//	如果没有保存过或者由旧版本的方法计算就先计算指标
+		err = workpool.CalculateStock(context.Background(), workpool.Shared(), code, prepare(timeframe, []Estimator{estimator}, store))
 		if err != nil {
 			return nil, err
 		}
-		peroid := int(peroid64)
+	}
 
-		n, err := strconv.ParseFloat(parts[2], 64)
-		if err != nil {
-			return nil, err
-		}
+	return load(code, kind, store)
+}
 
-		tr, err := strconv.ParseFloat(parts[3], 64)
-		if err != nil {
-			return nil, err
-		}
+//	保存指标
+func save(code, kind string, allIndex map[int][]TurtleIndex, store storage.Store) error {
+
+	records := make([]storage.Record, 0)
+	for peroid := peroidMin; peroid <= peroidMax; peroid++ {
 
 		indexes, found := allIndex[peroid]
 		if !found {
-			allIndex[peroid] = make([]TurtleIndex, 0)
-			indexes = allIndex[peroid]
+			return errors.New(fmt.Sprintf("保存海龟指标时发现缺失code=%s peroid=%d的指标", code, peroid))
+		}
+
+		for _, index := range indexes {
+			records = append(records, storage.Record{
+				Peroid: index.Peroid,
+				Date:   index.Date,
+				Values: []float64{index.N, index.TR},
+			})
 		}
+	}
+
+	return storage.SaveVersion(store, code, kind, records, indexVersion)
+}
+
+//	从存储中读入指标
+func load(code, kind string, store storage.Store) (map[int][]TurtleIndex, error) {
 
-		indexes = append(indexes, TurtleIndex{
-			Code:   code,
-			Peroid: peroid,
-			Date:   parts[1],
-			N:      n,
-			TR:     tr,
-		})
+	allIndex := make(map[int][]TurtleIndex)
+	err := storage.LoadColumns(store, code, kind, func(peroid int, dates []string, columns [][]float64) error {
+		if len(columns) != 2 {
+			return errors.New("海龟指标格式不正确")
+		}
+
+		indexes := make([]TurtleIndex, len(dates))
+		for index, date := range dates {
+			indexes[index] = TurtleIndex{
+				Code:   code,
+				Peroid: peroid,
+				Date:   date,
+				N:      columns[0][index],
+				TR:     columns[1][index],
+				Valid:  !math.IsNaN(columns[0][index]),
+			}
+		}
+		allIndex[peroid] = indexes
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return allIndex, nil
@@ -0,0 +1,145 @@
+package workpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/nzai/Tast/failure"
+)
+
+//	一只股票需要计算的一种数据，例如一种海龟指标，每个周期作为一项独立的工作
+type Kind struct {
+	Peroids   []int
+	Calculate func(peroid int) (interface{}, error)
+	//	所有周期都计算成功后保存，results的键为周期
+	Save func(results map[int]interface{}) error
+}
+
+//	准备一只股票的计算，返回尚未计算的数据种类，都已计算时返回空
+type Prepare func(code string) ([]Kind, error)
+
+var (
+	shared      *Pool
+	sharedMutex sync.Mutex
+)
+
+//	计算指标共用的工作池，第一次使用时按配置创建，
+//	各阶段的更新和按需进行的计算都使用它，因此同时进行的计算不超过配置的数量
+func Shared() *Pool {
+
+	sharedMutex.Lock()
+	defer sharedMutex.Unlock()
+
+	if shared == nil {
+		shared = New("indicator", DefaultWorkers())
+	}
+
+	return shared
+}
+
+//	用工作池计算多只股票，每只股票的每种数据的每个周期作为一项工作，
+//	一只股票的工作全部完成后依次保存各种数据并通过record记录结果，record可能在不同的goroutine中调用，
+//	stopped返回true或ctx被取消时不再提交其他股票，等待已提交的股票完成后返回
+func Calculate(ctx context.Context, pool *Pool, codes []string, prepare Prepare, record func(code string, err error), stopped func() bool) error {
+
+	var wait sync.WaitGroup
+	for _, code := range codes {
+		if stopped() || ctx.Err() != nil {
+			break
+		}
+
+		kinds, err := prepare(code)
+		if err != nil || len(kinds) == 0 {
+			record(code, err)
+			continue
+		}
+
+		code := code
+		wait.Add(1)
+		submit(ctx, pool, kinds, func(err error) {
+			record(code, err)
+			wait.Done()
+		})
+	}
+
+	wait.Wait()
+
+	return ctx.Err()
+}
+
+//	计算一只股票，返回计算或保存时发生的错误
+func CalculateStock(ctx context.Context, pool *Pool, code string, prepare Prepare) error {
+
+	var result error
+	err := Calculate(ctx, pool, []string{code}, prepare,
+		func(code string, err error) {
+			result = err
+		},
+		func() bool {
+			return false
+		})
+	if err != nil {
+		return err
+	}
+
+	return result
+}
+
+//	提交一只股票的所有工作，done在最后一项工作完成并保存后调用，提交被取消时以ctx的错误调用
+func submit(ctx context.Context, pool *Pool, kinds []Kind, done func(err error)) {
+
+	count := 0
+	for _, kind := range kinds {
+		count += len(kind.Peroids)
+	}
+
+	if count == 0 {
+		done(errors.New("没有需要计算的周期"))
+		return
+	}
+
+	//	各种数据按周期汇集的计算结果
+	var mutex sync.Mutex
+	results := make([]map[int]interface{}, len(kinds))
+	for index := range results {
+		results[index] = make(map[int]interface{}, len(kinds[index].Peroids))
+	}
+
+	group := NewGroup(count, func(err error) {
+		for index, kind := range kinds {
+			if err != nil {
+				break
+			}
+
+			err = kind.Save(results[index])
+		}
+		done(err)
+	})
+
+	for index, kind := range kinds {
+		for _, peroid := range kind.Peroids {
+			index, kind, peroid := index, kind, peroid
+			err := pool.Submit(ctx, func() {
+				result, err := kind.Calculate(peroid)
+				if err != nil {
+					group.Finish(failure.WithPeroid(peroid, err))
+					return
+				}
+
+				mutex.Lock()
+				results[index][peroid] = result
+				mutex.Unlock()
+				group.Finish(nil)
+			})
+			if err != nil {
+				//	未提交的工作以取消的错误结束，使done得到调用
+				for ; count > 0; count-- {
+					group.Finish(err)
+				}
+				return
+			}
+			count--
+		}
+	}
+}
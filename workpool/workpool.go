@@ -0,0 +1,103 @@
+package workpool
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	"github.com/nzai/Tast/config"
+	"github.com/nzai/Tast/metrics"
+)
+
+const (
+	configIndicatorSection = "indicator"
+	configWorkersKey       = "workers"
+)
+
+//	配置文件中指定的计算指标的并发数，不大于0时使用CPU数量
+func DefaultWorkers() int {
+	return config.GetInt(configIndicatorSection, configWorkersKey, 0)
+}
+
+//	有界的goroutine池，同时执行的工作不超过workers项
+type Pool struct {
+	name  string
+	tasks chan func()
+	wait  sync.WaitGroup
+}
+
+//	创建并启动工作池，name用作监控指标的标签，workers不大于0时使用CPU数量
+func New(name string, workers int) *Pool {
+
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	pool := &Pool{name: name, tasks: make(chan func())}
+	metrics.WorkersCapacity.Set(name, float64(workers))
+
+	pool.wait.Add(workers)
+	for index := 0; index < workers; index++ {
+		go pool.work()
+	}
+
+	return pool
+}
+
+func (pool *Pool) work() {
+
+	defer pool.wait.Done()
+
+	for task := range pool.tasks {
+		metrics.WorkersBusy.Inc(pool.name)
+		task()
+		metrics.WorkersBusy.Dec(pool.name)
+	}
+}
+
+//	提交一项工作，所有goroutine都在工作时阻塞，ctx被取消时放弃提交并返回错误
+func (pool *Pool) Submit(ctx context.Context, task func()) error {
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case pool.tasks <- task:
+		return nil
+	}
+}
+
+//	等待已提交的工作全部完成后结束所有goroutine，之后不能再提交
+func (pool *Pool) Close() {
+	close(pool.tasks)
+	pool.wait.Wait()
+}
+
+//	一组相关的工作，例如一只股票各个周期的计算，最后一项完成时调用done
+type Group struct {
+	mutex     sync.Mutex
+	remaining int
+	err       error
+	done      func(err error)
+}
+
+//	count项工作的组，done的参数为第一个错误，在完成最后一项工作的goroutine中调用
+func NewGroup(count int, done func(err error)) *Group {
+	return &Group{remaining: count, done: done}
+}
+
+//	一项工作完成
+func (group *Group) Finish(err error) {
+
+	group.mutex.Lock()
+	if err != nil && group.err == nil {
+		group.err = err
+	}
+	group.remaining--
+	last := group.remaining == 0
+	err = group.err
+	group.mutex.Unlock()
+
+	if last {
+		group.done(err)
+	}
+}
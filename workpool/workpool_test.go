@@ -0,0 +1,309 @@
+package workpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nzai/Tast/failure"
+)
+
+func TestPoolSubmitClose(t *testing.T) {
+
+	const workers, tasks = 4, 200
+
+	pool := New("test", workers)
+
+	var running, maxRunning, finished int32
+	for index := 0; index < tasks; index++ {
+		err := pool.Submit(context.Background(), func() {
+			current := atomic.AddInt32(&running, 1)
+			for {
+				previous := atomic.LoadInt32(&maxRunning)
+				if current <= previous || atomic.CompareAndSwapInt32(&maxRunning, previous, current) {
+					break
+				}
+			}
+
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			atomic.AddInt32(&finished, 1)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//	Close等待已提交的工作全部完成
+	pool.Close()
+
+	if finished != tasks {
+		t.Errorf("应完成%d项工作，实际完成%d项", tasks, finished)
+	}
+
+	if maxRunning > workers {
+		t.Errorf("同时执行的工作不应超过%d项，实际为%d项", workers, maxRunning)
+	}
+}
+
+func TestPoolSubmitCanceled(t *testing.T) {
+
+	pool := New("test", 1)
+	defer pool.Close()
+
+	//	唯一的goroutine被占用时提交会阻塞，ctx被取消后返回错误
+	release := make(chan struct{})
+	err := pool.Submit(context.Background(), func() { <-release })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+
+	err = pool.Submit(ctx, func() { t.Error("被取消的工作不应执行") })
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("提交被取消时应返回ctx的错误，实际为%v", err)
+	}
+}
+
+func TestGroupFinish(t *testing.T) {
+
+	const count = 100
+
+	var calls int32
+	var result error
+	finished := make(chan struct{})
+	group := NewGroup(count, func(err error) {
+		atomic.AddInt32(&calls, 1)
+		result = err
+		close(finished)
+	})
+
+	failed := errors.New("failed")
+	var wait sync.WaitGroup
+	for index := 0; index < count; index++ {
+		wait.Add(1)
+		go func(index int) {
+			defer wait.Done()
+			if index%10 == 3 {
+				group.Finish(failed)
+				return
+			}
+			group.Finish(nil)
+		}(index)
+	}
+	wait.Wait()
+	<-finished
+
+	if calls != 1 {
+		t.Errorf("done应只调用1次，实际调用%d次", calls)
+	}
+
+	if result != failed {
+		t.Errorf("done的参数应为发生的错误，实际为%v", result)
+	}
+}
+
+//	每个周期的结果为代码、种类和周期组成的字符串
+func testPrepare(kinds, peroids int, fail func(code string, kind, peroid int) bool, saved func(code string, kind int, results map[int]interface{})) Prepare {
+	return func(code string) ([]Kind, error) {
+
+		list := make([]Kind, 0, kinds)
+		for kind := 0; kind < kinds; kind++ {
+			kind := kind
+			peroidList := make([]int, 0, peroids)
+			for peroid := 1; peroid <= peroids; peroid++ {
+				peroidList = append(peroidList, peroid)
+			}
+
+			list = append(list, Kind{
+				Peroids: peroidList,
+				Calculate: func(peroid int) (interface{}, error) {
+					if fail != nil && fail(code, kind, peroid) {
+						return nil, errors.New("failed")
+					}
+
+					return fmt.Sprintf("%s/%d/%d", code, kind, peroid), nil
+				},
+				Save: func(results map[int]interface{}) error {
+					saved(code, kind, results)
+					return nil
+				},
+			})
+		}
+
+		return list, nil
+	}
+}
+
+func TestCalculate(t *testing.T) {
+
+	const kinds, peroids = 3, 49
+
+	codes := make([]string, 0, 20)
+	for index := 0; index < 20; index++ {
+		codes = append(codes, fmt.Sprintf("C%02d", index))
+	}
+
+	var mutex sync.Mutex
+	saved := make(map[string]int)
+	recorded := make(map[string]error)
+	pool := New("test", 8)
+	defer pool.Close()
+
+	err := Calculate(context.Background(), pool, codes,
+		testPrepare(kinds, peroids,
+			func(code string, kind, peroid int) bool {
+				return code == "C07" && kind == 1 && peroid == 30
+			},
+			func(code string, kind int, results map[int]interface{}) {
+				//	保存时所有周期的结果都已汇集
+				if len(results) != peroids {
+					t.Errorf("%s种类%d只有%d个周期的结果", code, kind, len(results))
+				}
+
+				for peroid, result := range results {
+					if result != fmt.Sprintf("%s/%d/%d", code, kind, peroid) {
+						t.Errorf("%s种类%d周期%d的结果为%v", code, kind, peroid, result)
+					}
+				}
+
+				mutex.Lock()
+				saved[code]++
+				mutex.Unlock()
+			}),
+		func(code string, err error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if _, found := recorded[code]; found {
+				t.Errorf("%s被记录了多次", code)
+			}
+			recorded[code] = err
+		},
+		func() bool {
+			return false
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, code := range codes {
+		err, found := recorded[code]
+		if !found {
+			t.Errorf("%s没有记录结果", code)
+			continue
+		}
+
+		if code != "C07" {
+			if err != nil || saved[code] != kinds {
+				t.Errorf("%s应保存%d种数据，实际保存%d种，错误为%v", code, kinds, saved[code], err)
+			}
+			continue
+		}
+
+		//	计算失败的股票不保存，错误带有周期
+		var peroidError *failure.PeroidError
+		if !errors.As(err, &peroidError) || peroidError.Peroid != 30 || saved[code] != 0 {
+			t.Errorf("%s应以周期30的错误结束且不保存，实际错误为%v，保存%d种", code, err, saved[code])
+		}
+	}
+}
+
+func TestCalculateCanceled(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := New("test", 2)
+	defer pool.Close()
+
+	var mutex sync.Mutex
+	recorded := make(map[string]error)
+	var started int32
+	prepare := func(code string) ([]Kind, error) {
+		peroids := make([]int, 100)
+		for index := range peroids {
+			peroids[index] = index + 1
+		}
+
+		return []Kind{{
+			Peroids: peroids,
+			Calculate: func(peroid int) (interface{}, error) {
+				//	开始计算后取消，之后的工作不再提交
+				if atomic.AddInt32(&started, 1) == 10 {
+					cancel()
+				}
+				time.Sleep(time.Millisecond)
+				return peroid, nil
+			},
+			Save: func(results map[int]interface{}) error {
+				t.Errorf("%s被取消后不应保存", code)
+				return nil
+			},
+		}}, nil
+	}
+
+	err := Calculate(ctx, pool, []string{"AAA", "BBB", "CCC"}, prepare,
+		func(code string, err error) {
+			mutex.Lock()
+			recorded[code] = err
+			mutex.Unlock()
+		},
+		func() bool {
+			return false
+		})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("被取消时应返回ctx的错误，实际为%v", err)
+	}
+
+	//	已经开始的股票以取消的错误结束，之后的股票不再处理
+	if !errors.Is(recorded["AAA"], context.Canceled) {
+		t.Errorf("AAA应以取消的错误结束，实际为%v", recorded["AAA"])
+	}
+
+	if len(recorded) != 1 {
+		t.Errorf("取消后不应再处理其他股票，实际记录了%v", recorded)
+	}
+}
+
+func TestCalculateStock(t *testing.T) {
+
+	pool := New("test", 4)
+	defer pool.Close()
+
+	var saved int32
+	err := CalculateStock(context.Background(), pool, "AAA", testPrepare(2, 10, nil,
+		func(code string, kind int, results map[int]interface{}) {
+			atomic.AddInt32(&saved, 1)
+		}))
+	if err != nil || saved != 2 {
+		t.Fatalf("应保存2种数据，实际保存%d种，错误为%v", saved, err)
+	}
+
+	err = CalculateStock(context.Background(), pool, "BBB", testPrepare(2, 10,
+		func(code string, kind, peroid int) bool {
+			return peroid == 5
+		},
+		func(code string, kind int, results map[int]interface{}) {
+			t.Error("计算失败时不应保存")
+		}))
+	if err == nil {
+		t.Error("计算失败时应返回错误")
+	}
+
+	//	准备失败时直接返回错误
+	failed := errors.New("no history")
+	err = CalculateStock(context.Background(), pool, "CCC", func(code string) ([]Kind, error) {
+		return nil, failed
+	})
+	if err != failed {
+		t.Errorf("应返回准备时的错误，实际为%v", err)
+	}
+}